@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxLocalIPs is the max number of the local IPs a CIDR can be expanded to
+const maxLocalIPs = 1 << 16
+
+// LocalIPs are the local IPs to bind, given by the comma separated IPs or CIDRs like 10.0.0.0/28
+type LocalIPs []net.IP
+
+func (l *LocalIPs) Type() string {
+	return "strings"
+}
+
+func (l *LocalIPs) String() string {
+	var ips []string
+	for _, ip := range *l {
+		ips = append(ips, ip.String())
+	}
+	return strings.Join(ips, ",")
+}
+
+func (l *LocalIPs) Set(s string) error {
+	for _, value := range strings.Split(s, ",") {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return fmt.Errorf("invalid IP %s", value)
+			}
+			*l = append(*l, ip)
+			continue
+		}
+		ips, err := expandCIDR(value)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, ips...)
+	}
+	return nil
+}
+
+// expandCIDR returns all the IPs of the CIDR, except the network and the broadcast addresses of IPv4
+func expandCIDR(cidr string) ([]net.IP, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("CIDR %s has more than %d IPs", cidr, maxLocalIPs)
+	}
+	ipv4 := ip.To4() != nil
+	if ipv4 {
+		ip = ip.To4()
+	}
+	var ips []net.IP
+	for ip = ip.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+		ips = append(ips, ip)
+	}
+	if ipv4 && bits-ones > 1 {
+		ips = ips[1 : len(ips)-1]
+	}
+	return ips, nil
+}
+
+// nextIP returns the IP after the given one
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
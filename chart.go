@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+const (
+	chartWidth        = 800
+	chartHeight       = 320
+	chartMarginLeft   = 70
+	chartMarginRight  = 20
+	chartMarginTop    = 20
+	chartMarginBottom = 50
+	chartTicks        = 5
+)
+
+var chartColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e"}
+
+// series is a named line (or bars) in a chart
+type series struct {
+	name string
+	x    []float64
+	y    []float64
+}
+
+// tick is a labeled position on an axis
+type tick struct {
+	value float64
+	label string
+}
+
+// chart is a line chart, or a bar chart if bars is true, rendered as an inline SVG
+type chart struct {
+	xLabel string
+	yLabel string
+	series []series
+	bars   bool
+	// xTicks overrides the evenly spaced ticks on the x axis, e.g. for a log scale
+	xTicks []tick
+	// formatX and formatY format the values of the evenly spaced ticks
+	formatX func(float64) string
+	formatY func(float64) string
+}
+
+// bounds returns the range of all series, y always starts from 0
+func (c *chart) bounds() (xMin, xMax, yMax float64) {
+	xMin, xMax = math.Inf(1), math.Inf(-1)
+	for _, s := range c.series {
+		for i := range s.x {
+			xMin = math.Min(xMin, s.x[i])
+			xMax = math.Max(xMax, s.x[i])
+			yMax = math.Max(yMax, s.y[i])
+		}
+	}
+	if math.IsInf(xMin, 1) {
+		xMin, xMax = 0, 1
+	}
+	if xMax == xMin {
+		xMax = xMin + 1
+	}
+	if yMax == 0 {
+		yMax = 1
+	}
+	return xMin, xMax, yMax * 1.05
+}
+
+// evenTicks returns chartTicks+1 evenly spaced ticks from min to max
+func evenTicks(min, max float64, format func(float64) string) []tick {
+	ticks := make([]tick, 0, chartTicks+1)
+	for i := 0; i <= chartTicks; i++ {
+		value := min + (max-min)*float64(i)/chartTicks
+		ticks = append(ticks, tick{value, format(value)})
+	}
+	return ticks
+}
+
+// SVG renders the chart
+func (c *chart) SVG() template.HTML {
+	xMin, xMax, yMax := c.bounds()
+	plotWidth := float64(chartWidth - chartMarginLeft - chartMarginRight)
+	plotHeight := float64(chartHeight - chartMarginTop - chartMarginBottom)
+	px := func(x float64) float64 { return chartMarginLeft + (x-xMin)/(xMax-xMin)*plotWidth }
+	py := func(y float64) float64 { return chartMarginTop + plotHeight - y/yMax*plotHeight }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, chartWidth, chartHeight)
+
+	// grid and ticks
+	xTicks := c.xTicks
+	if xTicks == nil {
+		xTicks = evenTicks(xMin, xMax, c.formatX)
+	}
+	for _, t := range xTicks {
+		x := px(t.value)
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%.1f" class="grid"/>`, x, chartMarginTop, x, chartMarginTop+plotHeight)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x, chartMarginTop+plotHeight+16, html.EscapeString(t.label))
+	}
+	for _, t := range evenTicks(0, yMax, c.formatY) {
+		y := py(t.value)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" class="grid"/>`, chartMarginLeft, y, chartMarginLeft+plotWidth, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`, chartMarginLeft-6, y+4, html.EscapeString(t.label))
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, chartMarginLeft+plotWidth/2, chartHeight-8, html.EscapeString(c.xLabel))
+	fmt.Fprintf(&b, `<text x="14" y="%.1f" text-anchor="middle" transform="rotate(-90 14 %.1f)">%s</text>`,
+		chartMarginTop+plotHeight/2, chartMarginTop+plotHeight/2, html.EscapeString(c.yLabel))
+
+	// data
+	for i, s := range c.series {
+		color := chartColors[i%len(chartColors)]
+		if c.bars {
+			width := plotWidth / float64(len(s.x)+1)
+			for j := range s.x {
+				fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+					px(s.x[j])-width/2, py(s.y[j]), math.Max(width-1, 1), py(0)-py(s.y[j]), color,
+					html.EscapeString(c.formatX(s.x[j])), html.EscapeString(c.formatY(s.y[j])))
+			}
+			continue
+		}
+		var points []string
+		for j := range s.x {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px(s.x[j]), py(s.y[j])))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), color)
+	}
+
+	// legend
+	if len(c.series) > 1 {
+		for i, s := range c.series {
+			x := chartMarginLeft + 10 + i*140
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`, x, chartMarginTop+4, chartColors[i%len(chartColors)])
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`, x+16, chartMarginTop+14, html.EscapeString(s.name))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	flag "github.com/spf13/pflag"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	COMPARE           = "compare"
+	defaultTolerance  = 10.0
+	toleranceAllKey   = ""
+	throughputMetric  = "throughput"
+	latencyMeanMetric = "mean"
+)
+
+// Tolerances is the max allowed regression in percentage, keyed by metric name
+// the empty key is the tolerance for all metrics without a specific one
+type Tolerances map[string]float64
+
+func (t *Tolerances) Type() string {
+	return "string"
+}
+
+func (t *Tolerances) String() string {
+	var tolerances []string
+	for metric, tolerance := range *t {
+		if metric == toleranceAllKey {
+			tolerances = append(tolerances, fmt.Sprintf("%g%%", tolerance))
+		} else {
+			tolerances = append(tolerances, fmt.Sprintf("%s=%g%%", metric, tolerance))
+		}
+	}
+	sort.Strings(tolerances)
+	return strings.Join(tolerances, ",")
+}
+
+// Set accepts either "10%" for all metrics or "p99=10%" for a single metric, the % is optional
+// the metric is one of throughput, mean or a percentile like p99.9
+func (t *Tolerances) Set(s string) error {
+	metric := toleranceAllKey
+	value := s
+	if i := strings.IndexByte(s, '='); i != -1 {
+		metric = strings.ToLower(strings.TrimSpace(s[:i]))
+		value = s[i+1:]
+		if metric == toleranceAllKey {
+			return errors.New("tolerance must be the format of 10% or metric=10%")
+		}
+	}
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percentage < 0 {
+		return errors.New("tolerance must be the format of 10% or metric=10%")
+	}
+	if metric != toleranceAllKey && metric != throughputMetric && metric != latencyMeanMetric {
+		percentile, err := strconv.ParseFloat(strings.TrimPrefix(metric, "p"), 64)
+		if !strings.HasPrefix(metric, "p") || err != nil || percentile < 0 || percentile > 100 {
+			return fmt.Errorf("tolerance of unknown metric %q, it must be throughput, mean or a percentile like p99.9", metric)
+		}
+		// the same name as the one compared, e.g. p99.90 is p99.9
+		metric = percentileMetric(percentile)
+	}
+	(*t)[metric] = percentage
+	return nil
+}
+
+// check returns an error if there is a tolerance of a metric not compared, e.g. a percentile missing in the results
+func (t Tolerances) check(deltas []metricDelta) error {
+	for metric := range t {
+		compared := metric == toleranceAllKey
+		for i := range deltas {
+			compared = compared || deltas[i].name == metric
+		}
+		if !compared {
+			return fmt.Errorf("tolerance of %s which is not in both results", metric)
+		}
+	}
+	return nil
+}
+
+// get returns the tolerance for the metric
+func (t Tolerances) get(metric string) float64 {
+	if tolerance, ok := t[metric]; ok {
+		return tolerance
+	}
+	return t[toleranceAllKey]
+}
+
+// metricDelta is the difference of one metric between the baseline and the current result
+type metricDelta struct {
+	name     string
+	baseline float64
+	current  float64
+	// format is used to print the baseline and the current value
+	format func(float64) string
+	// higherIsBetter is true for throughput, false for latencies
+	higherIsBetter bool
+}
+
+// change returns the change in percentage relative to the baseline
+func (m *metricDelta) change() float64 {
+	if m.baseline == 0 {
+		return 0
+	}
+	return 100.0 * (m.current - m.baseline) / m.baseline
+}
+
+// regression returns the regression in percentage, negative or zero if improved or unchanged
+func (m *metricDelta) regression() float64 {
+	if m.higherIsBetter {
+		return -m.change()
+	}
+	return m.change()
+}
+
+// regressed returns whether the regression is more than the tolerance in percentage
+func (m *metricDelta) regressed(tolerance float64) bool {
+	return m.regression() > tolerance
+}
+
+func formatLatency(us float64) string {
+	return fmt.Sprintf("%.3fms", us/1000.0)
+}
+
+func formatThroughput(rps float64) string {
+	return fmt.Sprintf("%.2f/s", rps)
+}
+
+// percentileMetric returns the name of the percentile, e.g. p99.9
+func percentileMetric(percentile float64) string {
+	return "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+// compareResults returns the deltas of throughput, mean latency and each percentile of the baseline
+func compareResults(baseline *Result, current *Result) []metricDelta {
+	deltas := []metricDelta{
+		{throughputMetric, baseline.Throughput, current.Throughput, formatThroughput, true},
+		{latencyMeanMetric, baseline.LatencyMean, current.LatencyMean, formatLatency, false},
+	}
+	for _, b := range baseline.Percentiles {
+		for _, c := range current.Percentiles {
+			if b.Percentile == c.Percentile {
+				name := percentileMetric(b.Percentile)
+				deltas = append(deltas, metricDelta{name, float64(b.Latency), float64(c.Latency), formatLatency, false})
+			}
+		}
+	}
+	return deltas
+}
+
+func printCompareUsages(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: %s %s <options> baseline.json current.json\nOptions:\n", APP, COMPARE)
+	flags.PrintDefaults()
+}
+
+// runCompare compares two results saved by --save, and returns the exit code
+// ERROR is returned if any metric regressed more than its tolerance
+func runCompare(args []string) int {
+	tolerances := Tolerances{toleranceAllKey: defaultTolerance}
+	flags := flag.NewFlagSet(COMPARE, flag.ContinueOnError)
+	flags.Usage = func() { printCompareUsages(flags) }
+	flags.SortFlags = false
+	flags.Var(&tolerances, "tolerance", "Max allowed regression in percentage, e.g. 5% for all metrics or p99=10% for one of throughput, mean, p50, p99.9, etc. Can be repeated")
+
+	err := flags.Parse(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		printCompareUsages(flags)
+		return ERROR
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "baseline and current result must be provided")
+		printCompareUsages(flags)
+		return ERROR
+	}
+	baseline, err := loadResult(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR
+	}
+	current, err := loadResult(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR
+	}
+
+	deltas := compareResults(baseline, current)
+	err = tolerances.check(deltas)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ERROR
+	}
+
+	exitCode := SUCCESS
+	headers := []string{"", "Baseline", "Current", "Delta", "Tolerance", "Result"}
+	var data [][]string
+	for _, delta := range deltas {
+		tolerance := tolerances.get(delta.name)
+		verdict := "ok"
+		if delta.regressed(tolerance) {
+			verdict = "REGRESSED"
+			exitCode = ERROR
+		}
+		data = append(data, []string{
+			delta.name,
+			delta.format(delta.baseline),
+			delta.format(delta.current),
+			fmt.Sprintf("%+.2f%%", delta.change()),
+			fmt.Sprintf("%.2f%%", tolerance),
+			verdict,
+		})
+	}
+	printTable(headers, data)
+	if exitCode != SUCCESS {
+		fmt.Println("\nPerformance regression detected")
+	}
+	return exitCode
+}
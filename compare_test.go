@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSetTolerance(t *testing.T) {
+	tests := []struct {
+		value   string
+		metric  string
+		want    float64
+		wantErr bool
+	}{
+		{value: "5%", metric: toleranceAllKey, want: 5},
+		{value: "5", metric: toleranceAllKey, want: 5},
+		{value: "throughput=2.5%", metric: throughputMetric, want: 2.5},
+		{value: "mean=0", metric: latencyMeanMetric, want: 0},
+		{value: "p99=10%", metric: "p99", want: 10},
+		{value: "P99.90 = 10%", metric: "p99.9", want: 10},
+		{value: "p100=1%", metric: "p100", want: 1},
+		{value: "p999=5%", wantErr: true},
+		{value: "p-1=5%", wantErr: true},
+		{value: "latency=5%", wantErr: true},
+		{value: "=5%", wantErr: true},
+		{value: "p99=-1%", wantErr: true},
+		{value: "p99=fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			tolerances := Tolerances{}
+			err := tolerances.Set(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Set(%q) = %v, want an error", tt.value, tolerances)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set(%q) = %v", tt.value, err)
+			}
+			if got, ok := tolerances[tt.metric]; !ok || got != tt.want || len(tolerances) != 1 {
+				t.Errorf("Set(%q) = %v, want %s=%g", tt.value, tolerances, tt.metric, tt.want)
+			}
+		})
+	}
+}
+
+// compareResult returns a Result of the throughput, the mean latency and the p50 and p99 latencies in microseconds
+func compareResult(throughput float64, mean float64, p50 int64, p99 int64) *Result {
+	return &Result{
+		Throughput:  throughput,
+		LatencyMean: mean,
+		Percentiles: []PercentileLatency{{Percentile: 50, Latency: p50}, {Percentile: 99, Latency: p99}},
+	}
+}
+
+func TestCompareResults(t *testing.T) {
+	baseline := compareResult(1000, 2000, 1000, 10000)
+	tests := []struct {
+		name    string
+		current *Result
+		// regressed are the metrics regressed more than 10%
+		regressed map[string]bool
+	}{
+		{name: "unchanged", current: compareResult(1000, 2000, 1000, 10000), regressed: map[string]bool{}},
+		{name: "within the tolerance", current: compareResult(910, 2200, 1100, 9000), regressed: map[string]bool{}},
+		{name: "improved", current: compareResult(2000, 1000, 500, 5000), regressed: map[string]bool{}},
+		{
+			name:      "lower throughput",
+			current:   compareResult(800, 2000, 1000, 10000),
+			regressed: map[string]bool{throughputMetric: true},
+		},
+		{
+			name:      "higher latencies",
+			current:   compareResult(1000, 2300, 1000, 12000),
+			regressed: map[string]bool{latencyMeanMetric: true, "p99": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas := compareResults(baseline, tt.current)
+			names := []string{throughputMetric, latencyMeanMetric, "p50", "p99"}
+			if len(deltas) != len(names) {
+				t.Fatalf("compareResults returned %d deltas, want %d", len(deltas), len(names))
+			}
+			for i := range deltas {
+				if deltas[i].name != names[i] {
+					t.Errorf("delta %d is %s, want %s", i, deltas[i].name, names[i])
+				}
+				if regressed := deltas[i].regressed(10); regressed != tt.regressed[deltas[i].name] {
+					t.Errorf("%s regressed %v with %+.2f%%, want %v",
+						deltas[i].name, regressed, deltas[i].change(), tt.regressed[deltas[i].name])
+				}
+			}
+		})
+	}
+}
+
+func TestCompareMissingPercentile(t *testing.T) {
+	baseline := compareResult(1000, 2000, 1000, 10000)
+	current := &Result{Throughput: 1000, LatencyMean: 2000, Percentiles: []PercentileLatency{{Percentile: 99, Latency: 10000}}}
+	deltas := compareResults(baseline, current)
+	for i := range deltas {
+		if deltas[i].name == "p50" {
+			t.Errorf("p50 missing in the current result is compared")
+		}
+	}
+	if err := (Tolerances{"p50": 5}).check(deltas); err == nil {
+		t.Errorf("the tolerance of p50 missing in the current result is accepted")
+	}
+	if err := (Tolerances{toleranceAllKey: 5, "p99": 5}).check(deltas); err != nil {
+		t.Errorf("the tolerance of p99 is rejected: %v", err)
+	}
+}
+
+func TestRunCompareExitCode(t *testing.T) {
+	dir := t.TempDir()
+	baselinePath := filepath.Join(dir, "baseline.json")
+	if err := saveResult(compareResult(1000, 2000, 1000, 10000), baselinePath); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		current *Result
+		flags   []string
+		want    int
+	}{
+		{name: "within the default tolerance", current: compareResult(950, 2100, 1050, 10500), want: SUCCESS},
+		{name: "regressed", current: compareResult(1000, 2000, 1000, 15000), want: ERROR},
+		{name: "regressed within its tolerance", current: compareResult(1000, 2000, 1000, 15000), flags: []string{"--tolerance", "p99=60%"}, want: SUCCESS},
+		{name: "regressed over the tolerance of all", current: compareResult(950, 2000, 1000, 10000), flags: []string{"--tolerance", "1%"}, want: ERROR},
+		{name: "unknown metric", current: compareResult(1000, 2000, 1000, 10000), flags: []string{"--tolerance", "p999=5%"}, want: ERROR},
+		{name: "percentile not in the results", current: compareResult(1000, 2000, 1000, 10000), flags: []string{"--tolerance", "p99.9=5%"}, want: ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currentPath := filepath.Join(dir, "current.json")
+			if err := saveResult(tt.current, currentPath); err != nil {
+				t.Fatal(err)
+			}
+			args := append(tt.flags, baselinePath, currentPath)
+			if got := runCompare(args); got != tt.want {
+				t.Errorf("runCompare(%v) = %d, want %d", args, got, tt.want)
+			}
+		})
+	}
+}
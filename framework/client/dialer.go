@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	rua "github.com/taoxinyi/rua/framework"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPorts are the ports of the URLs without one
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ws": "80", "wss": "443"}
+
+// dialer establishes the connections to the target for all the HttpClients, so that they connect in the same way
+type dialer struct {
+	// network is either tcp, or unix for a Unix domain socket
+	network string
+	// address is the host:port of the URL, or the path of the Unix domain socket
+	address string
+	timeout time.Duration
+	// host and port are of the URL, ips are the IPs of the host, the connections are spread across them
+	host string
+	port string
+	ips  []net.IP
+	// overridden is whether the IPs are given by LgConfig.Resolve instead of resolved
+	// reResolve is whether to resolve the host again for every new connection
+	overridden bool
+	reResolve  bool
+	// nextIP is the index of the next IP to connect to
+	nextIP uint32
+	// socket is the socket options set on every connection
+	socket rua.SocketConfig
+	// localIPs are the local IPs to bind in round robin, next is the index of the next one
+	localIPs []net.IP
+	next     uint32
+	// proxy is the proxy to connect through, nil to connect directly
+	proxy *url.URL
+	// forward is whether the connections are to the http proxy itself, and the requests are sent to it with the
+	// absolute URLs, instead of through a tunnel to the target. It's only for http URLs with an http proxy
+	forward bool
+
+	// log is the connections dialed by net/http or fasthttp for any User
+	log dialLog
+}
+
+// dialLog keeps the connections dialed by net/http or fasthttp in their own goroutines, until a User reports them
+type dialLog struct {
+	mutex sync.Mutex
+	dials []rua.Dial
+}
+
+// add keeps the connection dialed
+func (l *dialLog) add(dial rua.Dial) {
+	l.mutex.Lock()
+	l.dials = append(l.dials, dial)
+	l.mutex.Unlock()
+}
+
+// take appends the connections dialed but not reported yet to dials
+func (l *dialLog) take(dials []rua.Dial) []rua.Dial {
+	l.mutex.Lock()
+	dials = append(dials, l.dials...)
+	l.dials = l.dials[:0]
+	l.mutex.Unlock()
+	return dials
+}
+
+// dialLogKey is the key of the context value of the *dialLog of a User, so the connections net/http dials for its
+// requests are reported by it instead of any User
+type dialLogKey struct{}
+
+// newDialer returns the dialer to the target of the config, the host is resolved unless it's through a proxy
+func newDialer(config *rua.LgConfig) (*dialer, error) {
+	if config.UnixSocket != "" {
+		if config.Proxy != "" || len(config.LocalIPs) > 0 {
+			return nil, errors.New("proxy and local IPs are not supported with Unix domain socket")
+		}
+		return &dialer{network: "unix", address: config.UnixSocket, timeout: config.Timeout, socket: config.Socket}, nil
+	}
+	u, err := url.Parse(config.RequestConfig.URL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPorts[u.Scheme]
+	}
+	d := &dialer{
+		network:   "tcp",
+		address:   net.JoinHostPort(u.Hostname(), port),
+		timeout:   config.Timeout,
+		host:      u.Hostname(),
+		port:      port,
+		reResolve: config.ReResolve,
+		socket:    config.Socket,
+		localIPs:  config.LocalIPs,
+	}
+	d.ips, d.overridden = config.Resolve[d.address]
+	if config.Proxy != "" {
+		d.proxy, err = parseProxy(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		d.forward = d.proxy.Scheme == proxyHTTP && u.Scheme == "http"
+		// the proxy resolves the host unless it's overridden
+		return d, nil
+	}
+	if !d.overridden {
+		d.ips, err = d.resolve()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// resolve returns all the IPs of the host
+func (d *dialer) resolve() ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, d.host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// spread returns whether the connections are spread across more than one IP, so each IP is reported
+func (d *dialer) spread() bool {
+	return len(d.ips) > 1 || d.overridden || d.reResolve
+}
+
+// dial creates a new connection to the target, or a tunnel to it through the proxy
+func (d *dialer) dial() (conn net.Conn, dial rua.Dial, err error) {
+	netDialer := &net.Dialer{Timeout: d.timeout, KeepAlive: d.socket.KeepAlive}
+	if ip := d.nextLocalIP(); ip != nil {
+		netDialer.LocalAddr = &net.TCPAddr{IP: ip}
+		dial.LocalIP = ip.String()
+	}
+	if d.network == "unix" {
+		conn, dial.Connect, err = d.dialConn(netDialer, d.address)
+		return conn, dial, err
+	}
+	if d.proxy == nil {
+		return d.dialIPs(netDialer, dial)
+	}
+
+	start := time.Now()
+	conn, dial.Connect, err = d.dialConn(netDialer, d.proxy.Host)
+	if err != nil || d.forward {
+		dial.Proxy = time.Since(start)
+		return conn, dial, err
+	}
+	target := d.address
+	if d.overridden {
+		ip := d.ips[(atomic.AddUint32(&d.nextIP, 1)-1)%uint32(len(d.ips))]
+		target = net.JoinHostPort(ip.String(), d.port)
+		dial.RemoteIP = ip.String()
+	}
+	err = conn.SetDeadline(start.Add(d.timeout))
+	if err == nil {
+		if d.proxy.Scheme == proxyHTTP {
+			err = httpConnect(conn, d.proxy, target)
+		} else {
+			err = socks5Connect(conn, d.proxy, target, start.Add(d.timeout))
+		}
+	}
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, dial, err
+	}
+	dial.Proxy = time.Since(start)
+	return conn, dial, nil
+}
+
+// dialTLS creates a new connection like dial, then a TLS connection on it with the handshake measured
+func (d *dialer) dialTLS(config *tls.Config) (net.Conn, rua.Dial, error) {
+	conn, dial, err := d.dial()
+	if err != nil {
+		return nil, dial, err
+	}
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.SetDeadline(time.Now().Add(d.timeout))
+	if err == nil {
+		start := time.Now()
+		err = tlsConn.Handshake()
+		dial.TLS = true
+		dial.Handshake = time.Since(start)
+		dial.Resumed = tlsConn.ConnectionState().DidResume
+	}
+	if err == nil {
+		// the read deadline is set for each request
+		err = tlsConn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, dial, err
+	}
+	return tlsConn, dial, nil
+}
+
+// dialIPs connects to the IPs of the host in round robin, if it fails the other IPs are tried in order
+func (d *dialer) dialIPs(netDialer *net.Dialer, dial rua.Dial) (conn net.Conn, _ rua.Dial, err error) {
+	ips, err := d.nextIPs()
+	if err != nil {
+		return nil, dial, err
+	}
+	for _, ip := range ips {
+		conn, dial.Connect, err = d.dialConn(netDialer, net.JoinHostPort(ip.String(), d.port))
+		if err == nil {
+			if d.spread() {
+				dial.RemoteIP = ip.String()
+			}
+			return conn, dial, nil
+		}
+	}
+	return nil, dial, err
+}
+
+// dialConn connects to the address and sets the socket options, it returns the time to connect
+func (d *dialer) dialConn(netDialer *net.Dialer, address string) (net.Conn, time.Duration, error) {
+	start := time.Now()
+	conn, err := netDialer.Dial(d.network, address)
+	connect := time.Since(start)
+	if err != nil {
+		return nil, connect, err
+	}
+	conn, err = setSocketOptions(conn, &d.socket)
+	if err != nil {
+		conn.Close()
+		return nil, connect, err
+	}
+	return conn, connect, nil
+}
+
+// nextLocalIP returns the local IP to bind in round robin, nil if there is none
+func (d *dialer) nextLocalIP() net.IP {
+	if len(d.localIPs) == 0 {
+		return nil
+	}
+	return d.localIPs[(atomic.AddUint32(&d.next, 1)-1)%uint32(len(d.localIPs))]
+}
+
+// nextIPs returns the IPs of the host in the order to try, starting from the next one in round robin
+// the host is resolved again if reResolve
+func (d *dialer) nextIPs() (ips []net.IP, err error) {
+	ips = d.ips
+	if d.reResolve && !d.overridden {
+		ips, err = d.resolve()
+		if err != nil {
+			return nil, err
+		}
+	}
+	next := int((atomic.AddUint32(&d.nextIP, 1) - 1) % uint32(len(ips)))
+	return append(ips[next:len(ips):len(ips)], ips[:next]...), nil
+}
+
+// dialAddress ignores the address given by fasthttp, since the target is already known
+func (d *dialer) dialAddress(string) (net.Conn, error) {
+	return d.dialRecorded(&d.log)
+}
+
+// dialContext ignores the address given by net/http, since the target is already known
+// it's the proxy address if forward, which is also known
+func (d *dialer) dialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	if log, ok := ctx.Value(dialLogKey{}).(*dialLog); ok {
+		return d.dialRecorded(log)
+	}
+	return d.dialRecorded(&d.log)
+}
+
+// dialRecorded creates a new connection and keeps how it's dialed in the log, until a User reports it
+func (d *dialer) dialRecorded(log *dialLog) (net.Conn, error) {
+	conn, dial, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	log.add(dial)
+	return conn, nil
+}
@@ -0,0 +1,707 @@
+//go:build linux
+// +build linux
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// epollWaitMs is the max time in milliseconds an epoll_wait blocks, so that the stop is noticed in time
+	epollWaitMs = 10
+	// epollEvents is the max number of events returned by an epoll_wait
+	epollEvents = 1024
+	// epollMinScan and epollMaxScan bound the interval the connections are scanned for the timeouts
+	epollMinScan = time.Millisecond
+	epollMaxScan = 100 * time.Millisecond
+)
+
+// epollHttpClient sends the same requests as rawHttpClient, but drives non-blocking sockets from one epoll loop per
+// thread instead of a goroutine per connection, e.g. to hold 100k connections. Only http is supported, over TCP or
+// a Unix domain socket without a proxy
+type epollHttpClient struct {
+	rawHttpClient
+	// port is the port of the URL
+	port int
+	// socket is the socket options set on every connection
+	socket rua.SocketConfig
+	// scanInterval is the interval the connections are scanned for the timeouts
+	scanInterval int64
+}
+
+// NewEpollHttpClient returns a new epollHttpClient
+// the actual construction is implemented in Init
+func NewEpollHttpClient() *epollHttpClient {
+	return &epollHttpClient{}
+}
+
+func (c *epollHttpClient) Name() string {
+	return "epoll"
+}
+
+func (c *epollHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	err = c.rawHttpClient.Init(config, request)
+	if err != nil {
+		return err
+	}
+	if c.useTLS {
+		return errors.New("https is not supported by epoll")
+	}
+	if c.dialer.proxy != nil {
+		return errors.New("proxy is not supported by epoll")
+	}
+	if c.dialer.network != "unix" {
+		c.port, err = strconv.Atoi(c.dialer.port)
+		if err != nil {
+			return err
+		}
+	}
+	c.socket = config.Socket
+	scanInterval := c.timeout / 10
+	if scanInterval < epollMinScan {
+		scanInterval = epollMinScan
+	}
+	if scanInterval > epollMaxScan {
+		scanInterval = epollMaxScan
+	}
+	c.scanInterval = int64(scanInterval)
+	return nil
+}
+
+// CreateUser is not supported, the connections are driven by the Loops
+func (c *epollHttpClient) CreateUser() (rua.User, error) {
+	return nil, errors.New("epoll creates loops instead of users")
+}
+
+// CreateLoop creates an epoll instance and the connections of it, it returns once all of them are connected
+func (c *epollHttpClient) CreateLoop(connections int) (rua.Loop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	l := &epollLoop{
+		client: c,
+		epfd:   epfd,
+		conns:  make(map[int]*epollConn, connections),
+		events: make([]syscall.EpollEvent, epollEvents),
+	}
+	for i := 0; i < connections; i++ {
+		conn := &epollConn{fd: -1, rawResponse: RawResponse{rawBytes: make([]byte, c.maxResponseSize), head: c.head}}
+		if c.captureResponse {
+			conn.response.Headers = make(http.Header)
+		}
+		err = l.connect(conn)
+		if err != nil {
+			l.shutdown()
+			return nil, err
+		}
+	}
+	for l.connecting > 0 {
+		err = l.poll()
+		if err != nil {
+			l.shutdown()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// epollConn is a connection of an epollLoop, it's only used by the goroutine of the loop
+type epollConn struct {
+	// fd is -1 if the connection is closed
+	fd int
+	// connecting is whether the non-blocking connect is in progress, ips are the IPs to try next if it fails
+	// dial is how the connection is dialed, it's reported once connected
+	connecting bool
+	ips        []net.IP
+	local      net.IP
+	dial       rua.Dial
+	// connectStart is the time the connecting started, connected is the time it's connected
+	// requests is the number of requests sent on the connection
+	connectStart time.Time
+	connected    time.Time
+	requests     int
+	// events are the epoll events watched
+	events uint32
+	// written is the number of bytes of the requests written, pending is the number of responses not received yet
+	written int
+	pending int
+	// sent is the time the requests were written, deadline is the time the connection times out, in nanoseconds
+	sent     int64
+	deadline int64
+	// retried is whether the requests are resent on a new connection since the previous one was closed before the
+	// response, they are only resent once
+	retried     bool
+	rawResponse RawResponse
+	// rawResponse.rawBytes[start:n] are the bytes received but not parsed yet
+	// headerParsed is whether the headers of the response being received are parsed
+	start        int
+	n            int
+	headerParsed bool
+	// response is the Response being received, with the dials, the reconnects and the interim responses before it
+	response rua.Response
+}
+
+// epollLoop drives the connections of an epoll instance in one goroutine
+type epollLoop struct {
+	client *epollHttpClient
+	epfd   int
+	// conns are the connections by the fds, connecting is the number of them being connected
+	conns      map[int]*epollConn
+	connecting int
+	events     []syscall.EpollEvent
+	// closed are the fds removed but not closed yet, so that they are not reused within the events of an epoll_wait
+	closed []int
+	// failed are the connections ended by an error, they are dialed again before the next epoll_wait
+	failed []*epollConn
+	// nextScan is the time to scan the connections for the timeouts, in nanoseconds
+	nextScan int64
+	// recorder is nil until Run, the errors before it fail CreateLoop
+	recorder *rua.Recorder
+}
+
+func (l *epollLoop) Run(recorder *rua.Recorder) {
+	l.recorder = recorder
+	conns := make([]*epollConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		if c.fd >= 0 && !c.connecting {
+			l.check(c, l.send(c))
+		}
+	}
+	stopped := false
+	for len(l.conns) > 0 || len(l.failed) > 0 {
+		if !stopped && recorder.Stopped() {
+			// only the responses being received are waited for
+			stopped = true
+			l.failed = nil
+			for _, c := range l.conns {
+				if c.connecting || c.pending == 0 {
+					l.close(c)
+				}
+			}
+		}
+		l.redial()
+		err := l.poll()
+		if err != nil {
+			// the epoll instance failed, all the connections end
+			l.failed = nil
+			for _, c := range l.conns {
+				l.close(c)
+				recorder.Fail(err)
+			}
+		}
+	}
+	l.shutdown()
+}
+
+// poll waits for the events and handles them, then ends the connections timed out
+func (l *epollLoop) poll() error {
+	defer l.closeRemoved()
+	n, err := syscall.EpollWait(l.epfd, l.events, epollWaitMs)
+	if err != nil && err != syscall.EINTR {
+		return os.NewSyscallError("epoll_wait", err)
+	}
+	for i := 0; i < n; i++ {
+		c := l.conns[int(l.events[i].Fd)]
+		if c == nil {
+			// closed while handling the previous events
+			continue
+		}
+		err = l.check(c, l.handle(c, l.events[i].Events))
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now().UnixNano()
+	if now < l.nextScan {
+		return nil
+	}
+	l.nextScan = now + l.client.scanInterval
+	for _, c := range l.conns {
+		if (c.connecting || c.pending > 0) && now >= c.deadline {
+			err = l.check(c, os.ErrDeadlineExceeded)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check ends the connection if err is not nil, it's returned before Run starts. After that, it's recorded as a
+// connection error and the connection is dialed again, so that the number of connections is kept
+func (l *epollLoop) check(c *epollConn, err error) error {
+	if err == nil {
+		return nil
+	}
+	l.close(c)
+	if l.recorder == nil {
+		return err
+	}
+	l.recorder.FailConnection(&c.response, err)
+	c.response.Reconnects = 0
+	c.response.Recycled = 0
+	c.response.Interim = 0
+	c.response.Dials = c.response.Dials[:0]
+	c.retried = false
+	if !l.recorder.Stopped() {
+		l.failed = append(l.failed, c)
+	}
+	return nil
+}
+
+// redial dials the connections failed again, the ones failed to connect are retried in the next round
+func (l *epollLoop) redial() {
+	failed := l.failed
+	l.failed = nil
+	for _, c := range failed {
+		err := l.connect(c)
+		if err == nil {
+			c.response.Reconnects++
+		}
+		l.check(c, err)
+	}
+}
+
+// handle handles the epoll events of the connection
+func (l *epollLoop) handle(c *epollConn, events uint32) error {
+	if c.connecting {
+		if events&(syscall.EPOLLOUT|syscall.EPOLLERR|syscall.EPOLLHUP) == 0 {
+			return nil
+		}
+		return l.connected(c)
+	}
+	fd := c.fd
+	if events&(syscall.EPOLLIN|syscall.EPOLLERR|syscall.EPOLLHUP) != 0 {
+		err := l.read(c)
+		if err != nil || c.fd != fd {
+			// the connection is closed or replaced
+			return err
+		}
+	}
+	if events&syscall.EPOLLOUT != 0 {
+		return l.write(c)
+	}
+	return nil
+}
+
+// connect starts connecting to the Unix domain socket, or the next IP of the host in round robin
+func (l *epollLoop) connect(c *epollConn) (err error) {
+	d := l.client.dialer
+	if d.network == "unix" {
+		c.dial = rua.Dial{}
+		return l.open(c, syscall.AF_UNIX, &syscall.SockaddrUnix{Name: d.address})
+	}
+	c.ips, err = d.nextIPs()
+	if err != nil {
+		return err
+	}
+	c.local = d.nextLocalIP()
+	return l.connectNext(c)
+}
+
+// connectNext starts connecting to the first of the IPs left, the others are tried if it fails immediately
+func (l *epollLoop) connectNext(c *epollConn) (err error) {
+	err = errors.New("no IP to connect")
+	for len(c.ips) > 0 && err != nil {
+		ip := c.ips[0]
+		c.ips = c.ips[1:]
+		c.dial = rua.Dial{}
+		if l.client.dialer.spread() {
+			c.dial.RemoteIP = ip.String()
+		}
+		family, sa := sockaddr(ip, l.client.port)
+		err = l.open(c, family, sa)
+	}
+	return err
+}
+
+// sockaddr returns the address family and the socket address of the IP and the port
+func sockaddr(ip net.IP, port int) (int, syscall.Sockaddr) {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &syscall.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip4)
+		return syscall.AF_INET, sa
+	}
+	sa := &syscall.SockaddrInet6{Port: port}
+	copy(sa.Addr[:], ip.To16())
+	return syscall.AF_INET6, sa
+}
+
+// socketOption is an integer socket option
+type socketOption struct {
+	level int
+	name  int
+	value int
+}
+
+// setFdOptions sets the socket options on the fd, the same as setSocketOptions with net.Dialer does
+func setFdOptions(fd int, family int, socket *rua.SocketConfig) error {
+	var options []socketOption
+	if socket.RecvBuffer > 0 {
+		options = append(options, socketOption{syscall.SOL_SOCKET, syscall.SO_RCVBUF, socket.RecvBuffer})
+	}
+	if socket.SendBuffer > 0 {
+		options = append(options, socketOption{syscall.SOL_SOCKET, syscall.SO_SNDBUF, socket.SendBuffer})
+	}
+	if family != syscall.AF_UNIX {
+		noDelay := 1
+		if socket.Nagle {
+			noDelay = 0
+		}
+		options = append(options, socketOption{syscall.IPPROTO_TCP, syscall.TCP_NODELAY, noDelay})
+		if socket.KeepAlive >= 0 {
+			keepAlive := socket.KeepAlive
+			if keepAlive == 0 {
+				keepAlive = defaultKeepAlive
+			}
+			seconds := int((keepAlive + time.Second - 1) / time.Second)
+			options = append(options,
+				socketOption{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+				socketOption{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds},
+				socketOption{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds})
+		}
+		if socket.QuickAck {
+			options = append(options, socketOption{syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, 1})
+		}
+	}
+	for _, option := range options {
+		err := syscall.SetsockoptInt(fd, option.level, option.name, option.value)
+		if err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if family == syscall.AF_UNIX || socket.Linger == 0 {
+		return nil
+	}
+	// a negative linger resets the connection on close
+	linger := &syscall.Linger{Onoff: 1}
+	if socket.Linger > 0 {
+		linger.Linger = int32((socket.Linger + time.Second - 1) / time.Second)
+	}
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptLinger(fd, syscall.SOL_SOCKET, syscall.SO_LINGER, linger))
+}
+
+// open creates a non-blocking socket and starts connecting it to the address
+func (l *epollLoop) open(c *epollConn, family int, sa syscall.Sockaddr) error {
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	err = setFdOptions(fd, family, &l.client.socket)
+	if err == nil && c.local != nil && family != syscall.AF_UNIX {
+		_, local := sockaddr(c.local, 0)
+		err = os.NewSyscallError("bind", syscall.Bind(fd, local))
+		c.dial.LocalIP = c.local.String()
+	}
+	if err == nil {
+		err = syscall.Connect(fd, sa)
+		if err == syscall.EINPROGRESS {
+			err = nil
+		}
+		err = os.NewSyscallError("connect", err)
+	}
+	if err == nil {
+		event := &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLOUT, Fd: int32(fd)}
+		err = os.NewSyscallError("epoll_ctl", syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, event))
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	c.fd = fd
+	c.events = syscall.EPOLLIN | syscall.EPOLLOUT
+	c.connecting = true
+	c.connectStart = time.Now()
+	c.deadline = time.Now().UnixNano() + int64(l.client.timeout)
+	l.conns[fd] = c
+	l.connecting++
+	return nil
+}
+
+// connected is called once the connecting socket is writable, the requests are sent if the loop is running
+func (l *epollLoop) connected(c *epollConn) error {
+	errno, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+	if err == nil && errno != 0 {
+		err = syscall.Errno(errno)
+	}
+	if err != nil {
+		l.close(c)
+		if len(c.ips) > 0 {
+			return l.connectNext(c)
+		}
+		return os.NewSyscallError("connect", err)
+	}
+	c.connecting = false
+	l.connecting--
+	c.connected = time.Now()
+	c.dial.Connect = c.connected.Sub(c.connectStart)
+	c.requests = 0
+	c.response.Dials = append(c.response.Dials, c.dial)
+	if l.recorder == nil {
+		// the requests are sent once Run starts
+		return l.watch(c, syscall.EPOLLIN)
+	}
+	return l.send(c)
+}
+
+// watch changes the epoll events watched of the connection
+func (l *epollLoop) watch(c *epollConn, events uint32) error {
+	if c.events == events {
+		return nil
+	}
+	c.events = events
+	event := &syscall.EpollEvent{Events: events, Fd: int32(c.fd)}
+	return os.NewSyscallError("epoll_ctl", syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, c.fd, event))
+}
+
+// send starts writing all the pipelined requests
+func (l *epollLoop) send(c *epollConn) error {
+	if !c.retried {
+		for i := 0; i < l.client.pipeline; i++ {
+			l.recorder.Sent()
+		}
+	}
+	c.written = 0
+	c.pending = l.client.pipeline
+	c.requests += l.client.pipeline
+	c.sent = time.Now().UnixNano()
+	c.deadline = c.sent + int64(l.client.timeout)
+	return l.write(c)
+}
+
+// write writes the requests until all of them are written or the socket is not writable
+func (l *epollLoop) write(c *epollConn) error {
+	b := l.client.requestBytes
+	for c.written < len(b) {
+		n, err := syscall.Write(c.fd, b[c.written:])
+		if err == syscall.EAGAIN {
+			// continue once it's writable
+			return l.watch(c, syscall.EPOLLIN|syscall.EPOLLOUT)
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			if isConnectionClosed(err) {
+				return l.closedByServer(c, err)
+			}
+			return os.NewSyscallError("write", err)
+		}
+		c.written += n
+	}
+	return l.watch(c, syscall.EPOLLIN)
+}
+
+// read reads the bytes available once and parses the responses
+func (l *epollLoop) read(c *epollConn) error {
+	b := c.rawResponse.rawBytes
+	n, err := syscall.Read(c.fd, b[c.n:])
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil
+	}
+	if err != nil {
+		if isConnectionClosed(err) {
+			return l.closedByServer(c, err)
+		}
+		return os.NewSyscallError("read", err)
+	}
+	if n == 0 {
+		return l.closedByServer(c, io.EOF)
+	}
+	c.n += n
+	if l.client.socket.QuickAck {
+		// it's not permanent, so it's set again after every read
+		err = setQuickAck(c.fd)
+		if err != nil {
+			return err
+		}
+	}
+	return l.parse(c)
+}
+
+// parse parses the bytes received, each complete response is recorded
+// the bytes of a response always start from rawBytes[0], the body bytes are dropped once consumed
+func (l *epollLoop) parse(c *epollConn) error {
+	r := &c.rawResponse
+	b := r.rawBytes
+	for c.pending > 0 {
+		if !c.headerParsed {
+			if !r.CanStartParse(c.n) {
+				if c.n == len(b) {
+					// the empty line is not encountered but the buffer is full
+					return fmt.Errorf("Receiver buffer full, didn't encounter the end of headers after %d bytes", len(b))
+				}
+				return nil
+			}
+			err := r.Parse()
+			if err != nil {
+				return err
+			}
+			c.response.Size += r.bodyStart
+			if r.interim {
+				// an interim response has no body, the final response follows it
+				c.response.Interim++
+				c.n = copy(b, b[r.bodyStart:c.n])
+				r.ResetState()
+				continue
+			}
+			c.headerParsed = true
+			if l.client.captureResponse {
+				c.response.ResetCaptured()
+				r.FillHeaders(c.response.Headers)
+			}
+			c.start = r.bodyStart
+		}
+		var body *[]byte
+		if l.client.captureResponse {
+			body = &c.response.Body
+		}
+		consumed, complete, err := r.ConsumeBody(b[c.start:c.n], body)
+		if err != nil {
+			return err
+		}
+		c.response.Size += consumed
+		if !complete {
+			c.start, c.n = 0, 0
+			return nil
+		}
+		// keep the bytes of the next response
+		c.n = copy(b, b[c.start+consumed:c.n])
+		c.start = 0
+		fd := c.fd
+		err = l.complete(c)
+		if err != nil || c.fd != fd {
+			return err
+		}
+	}
+	return nil
+}
+
+// complete records the response received, the next requests are sent once all the responses are received
+func (l *epollLoop) complete(c *epollConn) error {
+	c.response.StatusCode = c.rawResponse.StatusCode
+	c.response.Pipelined = c.pending < l.client.pipeline
+	l.recorder.Record(&c.response, c.sent)
+	c.response.Size = 0
+	c.response.Reconnects = 0
+	c.response.Recycled = 0
+	c.response.Interim = 0
+	c.response.Dials = c.response.Dials[:0]
+	c.pending--
+	c.headerParsed = false
+	c.retried = false
+	c.rawResponse.ResetState()
+	c.deadline = time.Now().UnixNano() + int64(l.client.timeout)
+	switch {
+	case l.recorder.Stopped():
+		l.close(c)
+		return nil
+	case l.client.noKeepAlive && (c.pending == 0 || c.rawResponse.close):
+		// every request is sent on a new connection
+		l.close(c)
+		return l.connect(c)
+	case c.rawResponse.close:
+		// the server closes the connection after the response
+		return l.reconnect(c)
+	case c.pending == 0 && l.expired(c):
+		l.close(c)
+		err := l.connect(c)
+		if err == nil {
+			c.response.Recycled++
+		}
+		return err
+	case c.pending == 0:
+		return l.send(c)
+	}
+	return nil
+}
+
+// expired returns whether the connection reached the max requests or the max age, so it's replaced
+func (l *epollLoop) expired(c *epollConn) bool {
+	client := l.client
+	return (client.maxRequests > 0 && c.requests >= client.maxRequests) ||
+		(client.maxAge > 0 && time.Since(c.connected) >= client.maxAge)
+}
+
+// closedByServer handles the connection closed by the server
+// the body of the response may end with it, or the requests are resent once if no byte of the response is received
+// e.g. the server closed an idle keep-alive connection
+func (l *epollLoop) closedByServer(c *epollConn, err error) error {
+	if c.headerParsed && c.rawResponse.untilEOF && err == io.EOF {
+		// the body ends when the connection is closed
+		c.n = 0
+		c.rawResponse.close = true
+		return l.complete(c)
+	}
+	if c.pending == 0 {
+		return l.reconnect(c)
+	}
+	if c.n == 0 && !c.headerParsed && c.response.Size == 0 && !c.retried {
+		err = l.reconnect(c)
+		c.retried = true
+		return err
+	}
+	return err
+}
+
+// reconnect replaces the connection with a new one unless the loop is stopped
+func (l *epollLoop) reconnect(c *epollConn) error {
+	l.close(c)
+	if l.recorder != nil && l.recorder.Stopped() {
+		return nil
+	}
+	c.response.Reconnects++
+	return l.connect(c)
+}
+
+// close removes the connection from the loop and drops the responses not received, the fd is closed after the
+// events of the current epoll_wait are handled
+func (l *epollLoop) close(c *epollConn) {
+	if c.fd < 0 {
+		return
+	}
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	delete(l.conns, c.fd)
+	l.closed = append(l.closed, c.fd)
+	if c.connecting {
+		c.connecting = false
+		l.connecting--
+	}
+	c.fd = -1
+	c.pending = 0
+	c.start, c.n = 0, 0
+	c.headerParsed = false
+	c.rawResponse.ResetState()
+	c.response.Size = 0
+}
+
+// closeRemoved closes the fds of the connections removed
+func (l *epollLoop) closeRemoved() {
+	for _, fd := range l.closed {
+		syscall.Close(fd)
+	}
+	l.closed = l.closed[:0]
+}
+
+// shutdown closes all the connections and the epoll instance
+func (l *epollLoop) shutdown() {
+	for _, c := range l.conns {
+		l.close(c)
+	}
+	l.closeRemoved()
+	syscall.Close(l.epfd)
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+import (
+	"errors"
+	rua "github.com/taoxinyi/rua/framework"
+)
+
+// errEpollUnsupported is returned since epoll is only available on linux
+var errEpollUnsupported = errors.New("epoll is only supported on linux")
+
+// epollHttpClient is only supported on linux, it's registered so that the clients are the same on all platforms
+type epollHttpClient struct{}
+
+// NewEpollHttpClient returns a new epollHttpClient
+func NewEpollHttpClient() *epollHttpClient {
+	return &epollHttpClient{}
+}
+
+func (c *epollHttpClient) Name() string {
+	return "epoll"
+}
+
+func (c *epollHttpClient) Init(*rua.LgConfig, *rua.Request) error {
+	return errEpollUnsupported
+}
+
+func (c *epollHttpClient) CreateUser() (rua.User, error) {
+	return nil, errEpollUnsupported
+}
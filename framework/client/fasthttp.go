@@ -3,14 +3,22 @@ package client
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	rua "github.com/taoxinyi/rua/framework"
 	"github.com/valyala/fasthttp"
+	"net"
 )
 
 // fastHttpClient uses fasthttp.Client for the requests
 type fastHttpClient struct {
-	client  *fasthttp.Client
-	request *fasthttp.Request
+	client          *fasthttp.Client
+	dialer          *dialer
+	request         *fasthttp.Request
+	captureResponse bool
+	// noKeepAlive is whether each User has its own fasthttp.Client, so that it reports the connections it dials
+	noKeepAlive bool
+	config      *rua.LgConfig
+	tlsConfig   *tls.Config
 }
 
 // NewFastHttpClient returns a new fastHttpClient
@@ -25,34 +33,73 @@ func (c *fastHttpClient) Name() string {
 
 // Init creates a client pool and build the request for reuse
 func (c *fastHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
-	client := &fasthttp.Client{
-		NoDefaultUserAgentHeader:      true,
-		MaxConnsPerHost:               config.Connections,
-		ReadBufferSize:                config.RecvBufSize,
-		ReadTimeout:                   config.Timeout,
-		DisableHeaderNamesNormalizing: true,
+	if err = checkNoRecycling("fasthttp", config); err != nil {
+		return err
+	}
+	tlsConfig, err := config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	dialer, err := newDialer(config)
+	if err != nil {
+		return err
 	}
+	// fasthttp always sends the requests without the absolute URL, so http URLs are also tunneled through the proxy
+	dialer.forward = false
+	c.config = config
+	c.tlsConfig = tlsConfig
 	fastRequest := fasthttp.Request{}
 	err = fastRequest.Read(bufio.NewReader(bytes.NewBuffer(request.RawBytes)))
 	if err != nil {
 		return err
 	}
-	c.client = client
+	// the raw request has no scheme, which decides whether TLS is used
+	fastRequest.SetRequestURI(config.RequestConfig.URL)
+	c.client = c.newClient(dialer.dialAddress)
+	c.dialer = dialer
 	c.request = &fastRequest
+	c.captureResponse = config.CaptureResponse
+	c.noKeepAlive = config.NoKeepAlive
 	return nil
 }
 
+// newClient returns a fasthttp.Client dialing with the dial function
+func (c *fastHttpClient) newClient(dial fasthttp.DialFunc) *fasthttp.Client {
+	return &fasthttp.Client{
+		TLSConfig:                     c.tlsConfig,
+		Dial:                          dial,
+		NoDefaultUserAgentHeader:      true,
+		MaxConnsPerHost:               c.config.Connections,
+		ReadBufferSize:                c.config.RecvBufSize,
+		ReadTimeout:                   c.config.Timeout,
+		DisableHeaderNamesNormalizing: true,
+	}
+}
+
 func (c *fastHttpClient) CreateUser() (rua.User, error) {
 	request := &fasthttp.Request{}
 	c.request.CopyTo(request)
-	return &fastHttpUser{client: c.client, request: request}, nil
+	user := &fastHttpUser{client: c.client, dials: &c.dialer.log, request: request, captureResponse: c.captureResponse}
+	if c.noKeepAlive {
+		// the connection of each request is reported with its response, so that its latency excludes the connecting
+		dials := &dialLog{}
+		user.dials = dials
+		user.client = c.newClient(func(string) (net.Conn, error) {
+			return c.dialer.dialRecorded(dials)
+		})
+	}
+	return user, nil
 }
 
 // a fastHttpUser just grab a connection from the http.Client and send a requests, and wait for a response
 type fastHttpUser struct {
-	client   *fasthttp.Client
-	request  *fasthttp.Request
-	response fasthttp.Response
+	client *fasthttp.Client
+	// dials is shared by all the Users unless noKeepAlive, any of them reports the connections dialed by the
+	// fasthttp.Client
+	dials           *dialLog
+	request         *fasthttp.Request
+	response        fasthttp.Response
+	captureResponse bool
 }
 
 func (u *fastHttpUser) DoStaticRequest(response *rua.Response) (err error) {
@@ -60,8 +107,16 @@ func (u *fastHttpUser) DoStaticRequest(response *rua.Response) (err error) {
 	if err != nil {
 		return err
 	}
+	response.Dials = u.dials.take(response.Dials[:0])
 	response.StatusCode = u.response.Header.StatusCode()
 	// not accurate, only calculated body
 	response.Size = u.response.Header.ContentLength()
+	if u.captureResponse {
+		response.ResetCaptured()
+		u.response.Header.VisitAll(func(name, value []byte) {
+			response.Headers.Add(string(name), string(value))
+		})
+		response.Body = append(response.Body, u.response.Body()...)
+	}
 	return nil
 }
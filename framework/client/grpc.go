@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// grpcCodes are the names of the gRPC status codes, indexed by the codes
+var grpcCodes = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// grpcHTTPStatuses are the gRPC status codes of the responses without grpc-status by their HTTP status codes, e.g. from
+// a proxy, the others are UNKNOWN
+var grpcHTTPStatuses = map[int]string{
+	http.StatusBadRequest:         "INTERNAL",
+	http.StatusUnauthorized:       "UNAUTHENTICATED",
+	http.StatusForbidden:          "PERMISSION_DENIED",
+	http.StatusNotFound:           "UNIMPLEMENTED",
+	http.StatusTooManyRequests:    "UNAVAILABLE",
+	http.StatusBadGateway:         "UNAVAILABLE",
+	http.StatusServiceUnavailable: "UNAVAILABLE",
+	http.StatusGatewayTimeout:     "UNAVAILABLE",
+}
+
+// grpcHttpClient calls the unary RPCs of a gRPC method on the HTTP/2 connections of h2, the responses are counted by
+// their gRPC status codes
+type grpcHttpClient struct {
+	h2HttpClient
+	// output is the type of the response messages to decode the captured ones to JSON, nil without the descriptor set
+	output protoreflect.MessageDescriptor
+}
+
+// NewGRPCHttpClient returns a new grpcHttpClient
+// the actual construction is implemented in Init
+func NewGRPCHttpClient() *grpcHttpClient {
+	return &grpcHttpClient{}
+}
+
+func (c *grpcHttpClient) Name() string {
+	return "grpc"
+}
+
+func (c *grpcHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if err = checkNoRecycling("grpc", config); err != nil {
+		return err
+	}
+	method := strings.TrimPrefix(config.GRPC.Method, "/")
+	if strings.Count(method, "/") != 1 {
+		return errors.New("grpc requires the method like package.Service/Method")
+	}
+	// the message is the body in protobuf, unless it's given in JSON
+	message := config.RequestConfig.Body
+	if config.GRPC.DescriptorSet != "" {
+		descriptor, err := grpcMethod(config.GRPC.DescriptorSet, method)
+		if err != nil {
+			return err
+		}
+		if config.GRPC.Data != "" {
+			input := dynamicpb.NewMessage(descriptor.Input())
+			if err = protojson.Unmarshal([]byte(config.GRPC.Data), input); err != nil {
+				return fmt.Errorf("invalid %s in JSON: %v", descriptor.Input().FullName(), err)
+			}
+			message, err = proto.Marshal(input)
+			if err != nil {
+				return err
+			}
+		}
+		c.output = descriptor.Output()
+	} else if config.GRPC.Data != "" {
+		return errors.New("the message of grpc in JSON requires the descriptor set")
+	}
+	c.grpc = true
+	if err = c.h2HttpClient.Init(config, request); err != nil {
+		return err
+	}
+	c.block, err = h2HeaderBlock(grpcRequest(request.HttpRequest, method))
+	if err != nil {
+		return err
+	}
+	// the message is sent uncompressed with its length prefixed
+	c.body = make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(c.body[1:], uint32(len(message)))
+	c.body = append(c.body, message...)
+	return nil
+}
+
+// grpcMethod returns the unary method of the name like package.Service/Method in the FileDescriptorSet of the path
+func grpcMethod(path string, method string) (protoreflect.MethodDescriptor, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %v", path, err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %v", path, err)
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(strings.Replace(method, "/", ".", 1)))
+	if err != nil {
+		return nil, fmt.Errorf("method %s not found in %s", method, path)
+	}
+	descriptor, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", method)
+	}
+	if descriptor.IsStreamingClient() || descriptor.IsStreamingServer() {
+		return nil, fmt.Errorf("grpc only calls unary methods, %s is streaming", method)
+	}
+	return descriptor, nil
+}
+
+// grpcRequest returns the POST request of the method, the headers of the request are sent as the metadata
+func grpcRequest(request *http.Request, method string) *http.Request {
+	r := request.Clone(context.Background())
+	r.Method = http.MethodPost
+	r.URL.Path = "/" + method
+	r.URL.RawPath = ""
+	r.URL.RawQuery = ""
+	r.Header.Del("Content-Length")
+	if r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/grpc")
+	}
+	r.Header.Set("Te", "trailers")
+	return r
+}
+
+// CreateUser in grpcHttpClient assigns the User to a connection like h2
+func (c *grpcHttpClient) CreateUser() (rua.User, error) {
+	user, err := c.h2HttpClient.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+	return &grpcUser{h2User: user.(*h2User), output: c.output}, nil
+}
+
+// grpcHeaders reports the grpc-status of the headers, or the trailers which are also captured if capture
+func grpcHeaders(response *rua.Response, f *http2.MetaHeadersFrame, capture bool) {
+	for _, field := range f.RegularFields() {
+		if field.Name == "grpc-status" {
+			response.GRPCStatus = grpcCodeName(field.Value)
+		}
+		if capture {
+			name := http.CanonicalHeaderKey(field.Name)
+			response.Headers[name] = append(response.Headers[name], field.Value)
+		}
+	}
+}
+
+// grpcCodeName returns the name of the gRPC status code
+func grpcCodeName(code string) string {
+	if i, err := strconv.Atoi(code); err == nil && i >= 0 && i < len(grpcCodes) {
+		return grpcCodes[i]
+	}
+	return "CODE " + code
+}
+
+// grpcUser calls the RPCs one after another on its streams like h2
+type grpcUser struct {
+	*h2User
+	output protoreflect.MessageDescriptor
+}
+
+func (u *grpcUser) DoStaticRequest(response *rua.Response) (err error) {
+	response.GRPCStatus = ""
+	err = u.h2User.DoStaticRequest(response)
+	if err != nil {
+		return err
+	}
+	if response.GRPCStatus == "" {
+		// the response is not from the gRPC server, e.g. an error of a proxy
+		response.GRPCStatus = grpcHTTPStatuses[response.StatusCode]
+		if response.GRPCStatus == "" {
+			response.GRPCStatus = "UNKNOWN"
+		}
+	}
+	if u.captureResponse && len(response.Body) > 0 {
+		return u.decode(response)
+	}
+	return nil
+}
+
+// decode replaces the captured body with the response message, in JSON if the descriptor set is given
+func (u *grpcUser) decode(response *rua.Response) error {
+	body := response.Body
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return &rua.ProtocolError{Kind: "malformed gRPC message", Err: errors.New("not one uncompressed message")}
+	}
+	if u.output == nil {
+		response.Body = append(body[:0], body[5:]...)
+		return nil
+	}
+	message := dynamicpb.NewMessage(u.output)
+	if err := proto.Unmarshal(body[5:], message); err != nil {
+		return &rua.ProtocolError{Kind: "malformed gRPC message", Err: err}
+	}
+	b, err := protojson.Marshal(message)
+	if err != nil {
+		return err
+	}
+	response.Body = append(body[:0], b...)
+	return nil
+}
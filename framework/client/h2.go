@@ -0,0 +1,788 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// h2StreamWindow and h2ConnWindow are the receive windows of each stream and of the connection, they are large so
+	// that WINDOW_UPDATE is rarely sent. The credit is given back once half of a window is received
+	h2StreamWindow = 1 << 24
+	h2ConnWindow   = 1 << 30
+	// h2DefaultWindow and h2DefaultFrameSize are the initial values before the SETTINGS of the server
+	h2DefaultWindow    = 65535
+	h2DefaultFrameSize = 16384
+	// h2MaxStreamID is the largest stream ID, the connection is replaced once the IDs are used up
+	h2MaxStreamID = 1<<31 - 1
+)
+
+// errH2ConnUnusable is returned if no more streams can be opened on the connection, since it received GOAWAY, used up
+// the stream IDs or failed, so the request is retried on a new connection
+var errH2ConnUnusable = errors.New("http2 connection is not usable for new streams")
+
+// errH2Timeout is returned if the response of a stream is not received within the timeout
+var errH2Timeout = errors.New("timeout waiting for the http2 response")
+
+// h2ConnectionHeaders are the HTTP/1.1 connection specific headers which are not allowed in HTTP/2
+var h2ConnectionHeaders = map[string]bool{
+	"connection":        true,
+	"host":              true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// h2HttpClient speaks HTTP/2 on the connections, with TLS and ALPN for https, or h2c with prior knowledge for http
+// Each User is a stream, LgConfig.Streams of them share a connection
+type h2HttpClient struct {
+	dialer    *dialer
+	useTLS    bool
+	tlsConfig *tls.Config
+	// block is the HPACK encoded headers of the request, which is the same for every stream since the fields are never
+	// indexed. body is the body of the request, nil if there is none
+	block           []byte
+	body            []byte
+	timeout         time.Duration
+	captureResponse bool
+	streams         int
+	// grpc is whether the responses are of gRPC, so their grpc-status is reported
+	grpc bool
+
+	// mutex protects users and slot, the connection of the last User created
+	mutex sync.Mutex
+	users int
+	slot  *h2Slot
+}
+
+// NewH2HttpClient returns a new h2HttpClient
+// the actual construction is implemented in Init
+func NewH2HttpClient() *h2HttpClient {
+	return &h2HttpClient{}
+}
+
+func (c *h2HttpClient) Name() string {
+	return "h2"
+}
+
+func (c *h2HttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if config.NoKeepAlive {
+		return errors.New("h2 multiplexes the streams on the connections, it doesn't support no keep-alive")
+	}
+	if err = checkNoRecycling("h2", config); err != nil {
+		return err
+	}
+	u, err := url.Parse(config.RequestConfig.URL)
+	if err != nil {
+		return err
+	}
+	c.dialer, err = newDialer(config)
+	if err != nil {
+		return err
+	}
+	// h2c is tunneled with CONNECT through an http proxy, since it can't forward it
+	c.dialer.forward = false
+	c.useTLS = u.Scheme == "https"
+	c.tlsConfig, err = config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = u.Hostname()
+	}
+	if len(c.tlsConfig.NextProtos) == 0 {
+		c.tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+	}
+	c.block, err = h2HeaderBlock(request.HttpRequest)
+	if err != nil {
+		return err
+	}
+	if len(config.RequestConfig.Body) > 0 {
+		c.body = config.RequestConfig.Body
+	}
+	c.timeout = config.Timeout
+	c.captureResponse = config.CaptureResponse
+	c.streams = config.Streams
+	return nil
+}
+
+// h2HeaderBlock encodes the headers of the request as literals never indexed, so the block doesn't depend on the
+// state of the HPACK dynamic table and can be sent on any stream
+func h2HeaderBlock(request *http.Request) ([]byte, error) {
+	host := request.Host
+	if h := request.Header.Get("Host"); h != "" {
+		host = h
+	}
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: request.Method},
+		{Name: ":scheme", Value: request.URL.Scheme},
+		{Name: ":authority", Value: host},
+		{Name: ":path", Value: request.URL.RequestURI()},
+	}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if h2ConnectionHeaders[name] {
+			continue
+		}
+		for _, value := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: value})
+		}
+	}
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	for _, field := range fields {
+		field.Sensitive = true
+		if err := encoder.WriteField(field); err != nil {
+			return nil, err
+		}
+	}
+	return block.Bytes(), nil
+}
+
+// Streams is the number of Users sharing each connection
+func (c *h2HttpClient) Streams() int {
+	return c.streams
+}
+
+// CreateUser in h2HttpClient assigns the User to the connection of the previous Users, or a new one once it has
+// LgConfig.Streams Users
+func (c *h2HttpClient) CreateUser() (rua.User, error) {
+	c.mutex.Lock()
+	if c.users%c.streams == 0 {
+		c.slot = &h2Slot{dial: c.dial}
+	}
+	c.users++
+	slot := c.slot
+	c.mutex.Unlock()
+	// the connection is dialed by the first User of it, and reported with its first response
+	dialed := &rua.Response{}
+	if _, err := slot.get(dialed); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(c.timeout)
+	timer.Stop()
+	return &h2User{
+		slot:            slot,
+		dials:           dialed.Dials,
+		block:           c.block,
+		body:            c.body,
+		timeout:         c.timeout,
+		timer:           timer,
+		captureResponse: c.captureResponse,
+		stream:          h2Stream{done: make(chan error, 1)},
+	}, nil
+}
+
+// dial creates a new HTTP/2 connection, the TLS one must negotiate h2 with ALPN
+func (c *h2HttpClient) dial() (*h2Conn, rua.Dial, error) {
+	var conn net.Conn
+	var dial rua.Dial
+	var err error
+	if c.useTLS {
+		conn, dial, err = c.dialer.dialTLS(c.tlsConfig)
+		if err == nil {
+			if protocol := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; protocol != http2.NextProtoTLS {
+				conn.Close()
+				err = fmt.Errorf("the server negotiated %q instead of h2 with ALPN", protocol)
+			}
+		}
+	} else {
+		conn, dial, err = c.dialer.dial()
+	}
+	if err != nil {
+		return nil, dial, err
+	}
+	h2, err := newH2Conn(conn, c.timeout, c.captureResponse, c.grpc)
+	if err != nil {
+		conn.Close()
+		return nil, dial, err
+	}
+	return h2, dial, nil
+}
+
+// h2Slot is the connection shared by the Users of it, it's replaced once it can't open new streams
+type h2Slot struct {
+	dial  func() (*h2Conn, rua.Dial, error)
+	mutex sync.Mutex
+	conn  *h2Conn
+}
+
+// get returns the connection, a new one is dialed and reported in the Response if it can't open new streams
+// the old one is closed by itself once its streams end
+func (s *h2Slot) get(response *rua.Response) (*h2Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil && s.conn.usable() {
+		return s.conn, nil
+	}
+	conn, dial, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	response.Dials = append(response.Dials, dial)
+	if s.conn != nil {
+		response.Reconnects++
+	}
+	s.conn = conn
+	return conn, nil
+}
+
+// h2User sends the requests one after another on its streams of the shared connection
+type h2User struct {
+	slot *h2Slot
+	// dials are the connections dialed but not reported in a Response yet
+	dials           []rua.Dial
+	block           []byte
+	body            []byte
+	timeout         time.Duration
+	timer           *time.Timer
+	captureResponse bool
+	// stream is reused for every request, with a new ID
+	stream h2Stream
+}
+
+func (u *h2User) DoStaticRequest(response *rua.Response) (err error) {
+	response.Reconnects = 0
+	response.Interim = 0
+	// the connection dialed in CreateUser is reported with the first response
+	response.Dials = append(response.Dials[:0], u.dials...)
+	u.dials = nil
+	if u.captureResponse {
+		response.ResetCaptured()
+	}
+	u.stream.response = response
+	var conn *h2Conn
+	for retried := false; ; retried = true {
+		conn, err = u.slot.get(response)
+		if err != nil {
+			// the dial failed, the User ends
+			return err
+		}
+		err = conn.open(&u.stream, u.block, u.body)
+		if err == errH2ConnUnusable && !retried {
+			// e.g. GOAWAY is received after the connection is got, retry once on a new connection
+			continue
+		}
+		if err == errH2ConnUnusable {
+			// the new connection is unusable too, the next call gets another one
+			return &rua.ConnectionError{Err: err}
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	u.timer.Reset(u.timeout)
+	select {
+	case err = <-u.stream.done:
+		if !u.timer.Stop() {
+			<-u.timer.C
+		}
+		return err
+	case <-u.timer.C:
+		u.abandon(conn)
+		return errH2Timeout
+	}
+}
+
+// abandon cancels the stream whose response is not received within the timeout
+func (u *h2User) abandon(conn *h2Conn) {
+	if !conn.cancel(&u.stream) {
+		// the stream ended at the same time, drop its result so that the next request doesn't receive it
+		<-u.stream.done
+	}
+}
+
+// h2Stream is the state of the request on a stream, the fields other than done are only used by the read loop of the
+// connection once the stream is opened, until it's ended
+type h2Stream struct {
+	id uint32
+	// response is filled with the response of the stream
+	response *rua.Response
+	// done receives nil once the response is received, or the error ending the stream
+	done chan error
+	// sendWindow is the flow control window to send the body, protected by h2Conn.mutex
+	sendWindow int32
+	// final is whether the final headers are received, so the next headers are the trailers
+	final bool
+	// size is the size of the frames received, received is the size of the DATA frames not credited back yet
+	size     int
+	received int32
+}
+
+// h2Conn is an HTTP/2 connection, the streams are opened by the Users and the frames are read in a read loop
+type h2Conn struct {
+	conn            net.Conn
+	writer          *bufio.Writer
+	framer          *http2.Framer
+	timeout         time.Duration
+	captureResponse bool
+	grpc            bool
+	// writeMutex protects writer and framer for writing, the streams are opened in the order of their IDs
+	writeMutex sync.Mutex
+
+	// mutex protects the fields below, cond is signaled once a stream ends, the windows grow or the connection ends
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	streams map[uint32]*h2Stream
+	// active is the number of streams opened or being opened, maxStreams is SETTINGS_MAX_CONCURRENT_STREAMS
+	active     uint32
+	maxStreams uint32
+	nextID     uint32
+	// sendWindow is the flow control window of the connection, initialWindow and maxFrameSize are of the SETTINGS
+	sendWindow    int32
+	initialWindow int32
+	maxFrameSize  uint32
+	// goAway is the kind of the GOAWAY received like GOAWAY NO_ERROR, empty if none
+	goAway string
+	// err is the error ending the connection
+	err error
+
+	// received is the size of the DATA frames not credited back yet, only used by the read loop
+	received int32
+}
+
+// newH2Conn sends the connection preface with the SETTINGS and starts the read loop
+func newH2Conn(conn net.Conn, timeout time.Duration, captureResponse bool, grpc bool) (*h2Conn, error) {
+	c := &h2Conn{
+		conn:            conn,
+		writer:          bufio.NewWriter(conn),
+		timeout:         timeout,
+		captureResponse: captureResponse,
+		grpc:            grpc,
+		streams:         make(map[uint32]*h2Stream),
+		maxStreams:      math.MaxUint32,
+		nextID:          1,
+		sendWindow:      h2DefaultWindow,
+		initialWindow:   h2DefaultWindow,
+		maxFrameSize:    h2DefaultFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mutex)
+	c.framer = http2.NewFramer(c.writer, bufio.NewReader(conn))
+	c.framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	_, err := c.writer.WriteString(http2.ClientPreface)
+	if err == nil {
+		err = c.framer.WriteSettings(
+			http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: h2StreamWindow},
+		)
+	}
+	if err == nil {
+		err = c.framer.WriteWindowUpdate(0, h2ConnWindow-h2DefaultWindow)
+	}
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// usable returns whether new streams can be opened
+func (c *h2Conn) usable() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err == nil && c.goAway == ""
+}
+
+// open opens the stream with the HEADERS and sends the body, the response is received by the read loop
+// it waits if the server's SETTINGS_MAX_CONCURRENT_STREAMS is reached
+func (c *h2Conn) open(s *h2Stream, block []byte, body []byte) error {
+	c.mutex.Lock()
+	for c.err == nil && c.goAway == "" && c.active >= c.maxStreams {
+		c.cond.Wait()
+	}
+	if c.err != nil || c.goAway != "" {
+		c.mutex.Unlock()
+		return errH2ConnUnusable
+	}
+	c.active++
+	c.mutex.Unlock()
+
+	c.writeMutex.Lock()
+	c.mutex.Lock()
+	if c.err != nil || c.goAway != "" || c.nextID > h2MaxStreamID {
+		if c.nextID > h2MaxStreamID {
+			// no new streams, it's closed once the others end
+			c.goAway = "stream IDs used up"
+		}
+		c.endLocked()
+		c.mutex.Unlock()
+		c.writeMutex.Unlock()
+		return errH2ConnUnusable
+	}
+	s.id = c.nextID
+	c.nextID += 2
+	s.sendWindow = c.initialWindow
+	s.final = false
+	s.size = 0
+	s.received = 0
+	c.streams[s.id] = s
+	maxFrameSize := int(c.maxFrameSize)
+	c.mutex.Unlock()
+	err := c.writeHeaders(s.id, block, body == nil, maxFrameSize)
+	if err == nil {
+		err = c.flush()
+	}
+	c.writeMutex.Unlock()
+	if err == nil && body != nil {
+		err = c.writeBody(s, body)
+	}
+	if err != nil {
+		// the read loop ends the streams with the error
+		c.conn.Close()
+	}
+	return nil
+}
+
+// writeHeaders writes the HEADERS, and the CONTINUATION frames if the block is larger than the max frame size
+func (c *h2Conn) writeHeaders(id uint32, block []byte, endStream bool, maxFrameSize int) error {
+	n := len(block)
+	if n > maxFrameSize {
+		n = maxFrameSize
+	}
+	err := c.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: block[:n],
+		EndStream:     endStream,
+		EndHeaders:    n == len(block),
+	})
+	for block = block[n:]; err == nil && len(block) > 0; block = block[n:] {
+		n = len(block)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		err = c.framer.WriteContinuation(id, n == len(block), block[:n])
+	}
+	return err
+}
+
+// writeBody writes the body in DATA frames within the flow control windows
+func (c *h2Conn) writeBody(s *h2Stream, body []byte) error {
+	id := s.id
+	for len(body) > 0 {
+		c.mutex.Lock()
+		for c.err == nil && c.streams[id] == s && (c.sendWindow <= 0 || s.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+		if c.err != nil || c.streams[id] != s {
+			// the stream is already ended, e.g. reset by the server
+			c.mutex.Unlock()
+			return nil
+		}
+		n := len(body)
+		if n > int(c.sendWindow) {
+			n = int(c.sendWindow)
+		}
+		if n > int(s.sendWindow) {
+			n = int(s.sendWindow)
+		}
+		if n > int(c.maxFrameSize) {
+			n = int(c.maxFrameSize)
+		}
+		c.sendWindow -= int32(n)
+		s.sendWindow -= int32(n)
+		c.mutex.Unlock()
+		err := c.write(func() error {
+			return c.framer.WriteData(id, n == len(body), body[:n])
+		})
+		if err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+	return nil
+}
+
+// cancel ends the stream with RST_STREAM, e.g. since its response is not received within the timeout
+// it returns false if the stream already ended, whose result is sent to done then
+func (c *h2Conn) cancel(s *h2Stream) bool {
+	c.mutex.Lock()
+	if c.streams[s.id] != s {
+		c.mutex.Unlock()
+		return false
+	}
+	delete(c.streams, s.id)
+	c.endLocked()
+	c.mutex.Unlock()
+	c.write(func() error {
+		return c.framer.WriteRSTStream(s.id, http2.ErrCodeCancel)
+	})
+	return true
+}
+
+// endLocked releases a stream, the connection is closed once the last stream ends after GOAWAY
+func (c *h2Conn) endLocked() {
+	c.active--
+	c.cond.Broadcast()
+	if c.goAway != "" && c.active == 0 {
+		c.conn.Close()
+	}
+}
+
+// finish ends the stream with the error, or nil once the response is received
+func (c *h2Conn) finish(id uint32, err error) {
+	c.mutex.Lock()
+	s := c.streams[id]
+	if s == nil {
+		c.mutex.Unlock()
+		return
+	}
+	delete(c.streams, id)
+	c.endLocked()
+	c.mutex.Unlock()
+	s.response.Size = s.size
+	s.done <- err
+}
+
+// write writes a frame and flushes it
+func (c *h2Conn) write(write func() error) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	err := write()
+	if err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// flush flushes the frames written, it fails if the server doesn't read them within the timeout
+func (c *h2Conn) flush() error {
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// readLoop reads the frames until the connection ends, then ends all the streams left
+func (c *h2Conn) readLoop() {
+	err := c.read()
+	c.conn.Close()
+	c.mutex.Lock()
+	c.err = err
+	if c.goAway != "" {
+		// the server closed the connection after GOAWAY without finishing the streams
+		err = &rua.ProtocolError{Kind: c.goAway, Err: err}
+	} else {
+		// e.g. reset by the server, the Users go on with a new connection
+		err = &rua.ConnectionError{Err: err}
+	}
+	streams := c.streams
+	c.streams = make(map[uint32]*h2Stream)
+	c.active -= uint32(len(streams))
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	for _, s := range streams {
+		s.done <- err
+	}
+}
+
+// read reads and handles the frames, it returns the error ending the connection
+func (c *h2Conn) read() error {
+	for {
+		frame, err := c.framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+		switch f := frame.(type) {
+		case *http2.MetaHeadersFrame:
+			err = c.onHeaders(f)
+		case *http2.DataFrame:
+			err = c.onData(f)
+		case *http2.RSTStreamFrame:
+			c.finish(f.StreamID, &rua.ProtocolError{
+				Kind: "RST_STREAM " + f.ErrCode.String(),
+				Err:  http2.StreamError{StreamID: f.StreamID, Code: f.ErrCode},
+			})
+		case *http2.SettingsFrame:
+			err = c.onSettings(f)
+		case *http2.WindowUpdateFrame:
+			c.onWindowUpdate(f)
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				err = c.write(func() error {
+					return c.framer.WritePing(true, f.Data)
+				})
+			}
+		case *http2.GoAwayFrame:
+			c.onGoAway(f)
+		case *http2.PushPromiseFrame:
+			err = errors.New("http2 PUSH_PROMISE received with push disabled")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stream returns the stream of the ID, nil if it's already ended, e.g. cancelled
+func (c *h2Conn) stream(id uint32) *h2Stream {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.streams[id]
+}
+
+// onHeaders handles the headers, the interim 1xx ones, the final ones, or the trailers
+func (c *h2Conn) onHeaders(f *http2.MetaHeadersFrame) error {
+	s := c.stream(f.StreamID)
+	if s == nil {
+		return nil
+	}
+	s.size += int(f.Header().Length)
+	trailers := s.final
+	if !trailers {
+		status, err := strconv.Atoi(f.PseudoValue("status"))
+		if err != nil {
+			return fmt.Errorf("http2 malformed :status %q", f.PseudoValue("status"))
+		}
+		if status < 200 {
+			// an interim response, the final one follows it
+			s.response.Interim++
+			return nil
+		}
+		s.final = true
+		s.response.StatusCode = status
+		if c.captureResponse {
+			for _, field := range f.RegularFields() {
+				name := http.CanonicalHeaderKey(field.Name)
+				s.response.Headers[name] = append(s.response.Headers[name], field.Value)
+			}
+		}
+	}
+	if c.grpc {
+		grpcHeaders(s.response, f, trailers && c.captureResponse)
+	}
+	if f.StreamEnded() {
+		c.finish(f.StreamID, nil)
+	}
+	return nil
+}
+
+// onData handles the body, the flow control credits are given back once half of the windows are received
+func (c *h2Conn) onData(f *http2.DataFrame) error {
+	length := int32(f.Header().Length)
+	c.received += length
+	if c.received >= h2ConnWindow/2 {
+		received := c.received
+		c.received = 0
+		err := c.write(func() error {
+			return c.framer.WriteWindowUpdate(0, uint32(received))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s := c.stream(f.StreamID)
+	if s == nil {
+		return nil
+	}
+	data := f.Data()
+	s.size += len(data)
+	if c.captureResponse {
+		s.response.Body = append(s.response.Body, data...)
+	}
+	if f.StreamEnded() {
+		c.finish(f.StreamID, nil)
+		return nil
+	}
+	s.received += length
+	if s.received >= h2StreamWindow/2 {
+		received := s.received
+		s.received = 0
+		return c.write(func() error {
+			return c.framer.WriteWindowUpdate(f.StreamID, uint32(received))
+		})
+	}
+	return nil
+}
+
+// onSettings applies the SETTINGS of the server and acknowledges them
+func (c *h2Conn) onSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	c.mutex.Lock()
+	err := f.ForeachSetting(func(setting http2.Setting) error {
+		if err := setting.Valid(); err != nil {
+			return err
+		}
+		switch setting.ID {
+		case http2.SettingMaxConcurrentStreams:
+			c.maxStreams = setting.Val
+		case http2.SettingInitialWindowSize:
+			// the windows of the streams opened change by the difference
+			delta := int32(setting.Val) - c.initialWindow
+			for _, s := range c.streams {
+				s.sendWindow += delta
+			}
+			c.initialWindow = int32(setting.Val)
+		case http2.SettingMaxFrameSize:
+			c.maxFrameSize = setting.Val
+		}
+		return nil
+	})
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.write(c.framer.WriteSettingsAck)
+}
+
+// onWindowUpdate grows the flow control window of the connection or the stream
+func (c *h2Conn) onWindowUpdate(f *http2.WindowUpdateFrame) {
+	c.mutex.Lock()
+	if f.StreamID == 0 {
+		c.sendWindow += int32(f.Increment)
+	} else if s := c.streams[f.StreamID]; s != nil {
+		s.sendWindow += int32(f.Increment)
+	}
+	c.cond.Broadcast()
+	c.mutex.Unlock()
+}
+
+// onGoAway stops opening new streams, the streams after the last one processed by the server end with the GOAWAY
+// the connection is closed once the others end
+func (c *h2Conn) onGoAway(f *http2.GoAwayFrame) {
+	kind := "GOAWAY " + f.ErrCode.String()
+	err := &rua.ProtocolError{
+		Kind: kind,
+		Err:  http2.GoAwayError{LastStreamID: f.LastStreamID, ErrCode: f.ErrCode, DebugData: string(f.DebugData())},
+	}
+	c.mutex.Lock()
+	c.goAway = kind
+	var refused []*h2Stream
+	for id, s := range c.streams {
+		if id > f.LastStreamID {
+			delete(c.streams, id)
+			refused = append(refused, s)
+		}
+	}
+	c.active -= uint32(len(refused))
+	c.cond.Broadcast()
+	if c.active == 0 {
+		c.conn.Close()
+	}
+	c.mutex.Unlock()
+	for _, s := range refused {
+		s.done <- err
+	}
+}
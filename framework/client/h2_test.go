@@ -0,0 +1,149 @@
+package client
+
+import (
+	"bytes"
+	rua "github.com/taoxinyi/rua/framework"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// h2StubServer answers the requests on its h2c connections after the delays in turn, the body is the ID of the stream
+func h2StubServer(t *testing.T, delays []time.Duration) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveH2Stub(conn, delays)
+		}
+	}()
+	return ln
+}
+
+func serveH2Stub(conn net.Conn, delays []time.Duration) {
+	defer conn.Close()
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil {
+		return
+	}
+	framer := http2.NewFramer(conn, conn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	var mutex sync.Mutex
+	var block bytes.Buffer
+	encoder := hpack.NewEncoder(&block)
+	if framer.WriteSettings() != nil {
+		return
+	}
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return
+		}
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				mutex.Lock()
+				framer.WriteSettingsAck()
+				mutex.Unlock()
+			}
+		case *http2.MetaHeadersFrame:
+			id := f.StreamID
+			delay := delays[int(id/2)%len(delays)]
+			time.AfterFunc(delay, func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				block.Reset()
+				encoder.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block.Bytes(), EndHeaders: true})
+				framer.WriteData(id, true, []byte(strconv.FormatUint(uint64(id), 10)))
+			})
+		}
+	}
+}
+
+// newH2StubUser returns a User of h2 to the stub server, with the responses captured
+func newH2StubUser(t *testing.T, ln net.Listener) *h2User {
+	t.Helper()
+	config := &rua.LgConfig{
+		RequestConfig:   rua.RequestConfig{Method: http.MethodGet, URL: "http://" + ln.Addr().String() + "/"},
+		Timeout:         time.Second,
+		CaptureResponse: true,
+		Streams:         1,
+	}
+	httpRequest, err := http.NewRequest(config.RequestConfig.Method, config.RequestConfig.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewH2HttpClient()
+	if err = c.Init(config, &rua.Request{HttpRequest: httpRequest}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := c.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.(*h2User)
+}
+
+func TestH2TimeoutAtResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		// delay is the delay of the response of the timed out stream
+		delay time.Duration
+		// ended is whether the response is received before the timeout is handled, i.e. both are ready in select
+		ended bool
+	}{
+		{name: "response after the timeout", delay: time.Second},
+		{name: "response at the timeout", ended: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first stream is timed out, the second one is answered at once
+			ln := h2StubServer(t, []time.Duration{tt.delay, 0})
+			defer ln.Close()
+			u := newH2StubUser(t, ln)
+			response := &rua.Response{Headers: http.Header{}}
+			u.stream.response = response
+			conn, err := u.slot.get(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = conn.open(&u.stream, u.block, u.body); err != nil {
+				t.Fatal(err)
+			}
+			if tt.ended {
+				// wait until the response is received, as if it's received right when the timer fires
+				for start := time.Now(); len(u.stream.done) == 0; time.Sleep(time.Millisecond) {
+					if time.Since(start) > time.Second {
+						t.Fatal("no response of the first stream")
+					}
+				}
+			}
+			u.abandon(conn)
+			if len(u.stream.done) != 0 {
+				t.Fatal("the result of the timed out stream is left")
+			}
+
+			if err = u.DoStaticRequest(response); err != nil {
+				t.Fatal(err)
+			}
+			// a stale result of the timed out stream would have its ID
+			if want := strconv.FormatUint(uint64(u.stream.id), 10); string(response.Body) != want {
+				t.Errorf("the request on stream %s received the response of stream %s", want, response.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	rua "github.com/taoxinyi/rua/framework"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errH3Timeout is returned if the response of a request is not received within the timeout
+var errH3Timeout = errors.New("timeout waiting for the http3 response")
+
+// h3HttpClient speaks HTTP/3 over QUIC connections, each User is a stream, LgConfig.Streams of them share a connection
+type h3HttpClient struct {
+	dialer     *dialer
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	transport  *http3.Transport
+	request    *http.Request
+	body       []byte
+	timeout    time.Duration
+	// maxRequests is the number of requests sent on a connection before it's replaced, 0 for no limit
+	// maxAge is the time a connection is used before it's replaced, 0 for no limit
+	maxRequests     int
+	maxAge          time.Duration
+	captureResponse bool
+	streams         int
+
+	// mutex protects users and slot, the connection of the last User created
+	mutex sync.Mutex
+	users int
+	slot  *h3Slot
+}
+
+// NewH3HttpClient returns a new h3HttpClient
+// the actual construction is implemented in Init
+func NewH3HttpClient() *h3HttpClient {
+	return &h3HttpClient{}
+}
+
+func (c *h3HttpClient) Name() string {
+	return "h3"
+}
+
+func (c *h3HttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if config.UnixSocket != "" || config.Proxy != "" {
+		return errors.New("h3 runs over UDP, it doesn't support Unix domain socket or proxy")
+	}
+	if config.NoKeepAlive {
+		return errors.New("h3 multiplexes the streams on the connections, it doesn't support no keep-alive")
+	}
+	u, err := url.Parse(config.RequestConfig.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return errors.New("h3 only supports https urls")
+	}
+	c.dialer, err = newDialer(config)
+	if err != nil {
+		return err
+	}
+	c.tlsConfig, err = config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = u.Hostname()
+	}
+	if len(c.tlsConfig.NextProtos) == 0 {
+		c.tlsConfig.NextProtos = []string{http3.NextProtoH3}
+	}
+	c.quicConfig = &quic.Config{HandshakeIdleTimeout: config.Timeout}
+	// the responses are counted as they are received
+	c.transport = &http3.Transport{DisableCompression: true}
+	c.request = request.HttpRequest
+	if len(config.RequestConfig.Body) > 0 {
+		c.body = config.RequestConfig.Body
+	}
+	c.timeout = config.Timeout
+	c.maxRequests = config.MaxRequestsPerConn
+	if handshakeEvery := config.TLS.HandshakeEvery; handshakeEvery > 0 && (c.maxRequests == 0 || handshakeEvery < c.maxRequests) {
+		c.maxRequests = handshakeEvery
+	}
+	c.maxAge = config.MaxConnAge
+	c.captureResponse = config.CaptureResponse
+	c.streams = config.Streams
+	return nil
+}
+
+// Streams is the number of Users sharing each connection
+func (c *h3HttpClient) Streams() int {
+	return c.streams
+}
+
+// CreateUser in h3HttpClient assigns the User to the connection of the previous Users, or a new one once it has
+// LgConfig.Streams Users
+func (c *h3HttpClient) CreateUser() (rua.User, error) {
+	c.mutex.Lock()
+	if c.users%c.streams == 0 {
+		tlsConfig := c.tlsConfig.Clone()
+		if tlsConfig.ClientSessionCache != nil {
+			// the sessions are cached for each connection, quic-go doesn't resume a session concurrently safely
+			tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+		c.slot = &h3Slot{
+			dial: func() (*h3Conn, rua.Dial, error) {
+				return c.dial(tlsConfig)
+			},
+			maxRequests: c.maxRequests,
+			maxAge:      c.maxAge,
+		}
+	}
+	c.users++
+	slot := c.slot
+	c.mutex.Unlock()
+	// the connection is dialed by the first User of it, and reported with its first response
+	dialed := &rua.Response{}
+	if err := slot.connect(dialed); err != nil {
+		return nil, err
+	}
+	return &h3User{
+		slot:            slot,
+		dials:           dialed.Dials,
+		request:         c.request,
+		body:            c.body,
+		timeout:         c.timeout,
+		captureResponse: c.captureResponse,
+	}, nil
+}
+
+// dial establishes a new QUIC connection from its own UDP socket with the TLS config of the slot, the requests are sent once the handshake completes
+// so it's measured with its kind, full, resumed or 0-RTT. The 0-RTT data is only the QUIC handshake then, no request
+// is sent early, so the latency of a request in 0-RTT is not measured
+func (c *h3HttpClient) dial(tlsConfig *tls.Config) (*h3Conn, rua.Dial, error) {
+	var dial rua.Dial
+	ips, err := c.dialer.nextIPs()
+	if err != nil {
+		return nil, dial, err
+	}
+	if c.dialer.spread() {
+		dial.RemoteIP = ips[0].String()
+	}
+	local := &net.UDPAddr{}
+	if ip := c.dialer.nextLocalIP(); ip != nil {
+		local.IP = ip
+		dial.LocalIP = ip.String()
+	}
+	remote, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), c.dialer.port))
+	if err != nil {
+		return nil, dial, err
+	}
+	udp, err := net.ListenUDP("udp", local)
+	if err != nil {
+		return nil, dial, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := quic.DialEarly(ctx, udp, remote, tlsConfig, c.quicConfig)
+	if err == nil {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			err = context.Cause(conn.Context())
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			conn.CloseWithError(0, "")
+		}
+	}
+	if err != nil {
+		udp.Close()
+		return nil, dial, err
+	}
+	state := conn.ConnectionState()
+	dial.TLS = true
+	dial.Handshake = time.Since(start)
+	dial.Resumed = state.TLS.DidResume
+	dial.EarlyData = state.Used0RTT
+	return &h3Conn{conn: conn, udp: udp, client: c.transport.NewClientConn(conn), connected: time.Now()}, dial, nil
+}
+
+// h3Conn is a QUIC connection with its UDP socket, the fields other than conn, udp and client are protected by
+// h3Slot.mutex
+type h3Conn struct {
+	conn   quic.EarlyConnection
+	udp    *net.UDPConn
+	client *http3.ClientConn
+	// requests is the number of requests sent on it, active is the number of them not finished yet
+	// connected is the time it's dialed, retired is whether it's replaced so it's closed once active is 0
+	requests  int
+	active    int
+	connected time.Time
+	retired   bool
+}
+
+// close closes the connection and its UDP socket
+func (c *h3Conn) close() {
+	c.conn.CloseWithError(0, "")
+	c.udp.Close()
+}
+
+// h3Slot is the connection shared by the Users of it, it's replaced once it's closed or it expires
+type h3Slot struct {
+	dial        func() (*h3Conn, rua.Dial, error)
+	maxRequests int
+	maxAge      time.Duration
+	mutex       sync.Mutex
+	conn        *h3Conn
+}
+
+// connect dials the connection if it's not dialed yet, it's reported in the Response
+func (s *h3Slot) connect(response *rua.Response) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		return nil
+	}
+	return s.connectLocked(response)
+}
+
+// connectLocked dials a new connection and reports it in the Response
+func (s *h3Slot) connectLocked(response *rua.Response) error {
+	conn, dial, err := s.dial()
+	if err != nil {
+		return err
+	}
+	response.Dials = append(response.Dials, dial)
+	s.conn = conn
+	return nil
+}
+
+// get returns the connection for a request, a new one is dialed and reported in the Response if it's closed or it
+// reached maxRequests or maxAge. release must be called once the request finishes
+func (s *h3Slot) get(response *rua.Response) (*h3Conn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	closed := s.conn.conn.Context().Err() != nil
+	expired := (s.maxRequests > 0 && s.conn.requests >= s.maxRequests) ||
+		(s.maxAge > 0 && time.Since(s.conn.connected) >= s.maxAge)
+	if closed || expired || s.conn.retired {
+		// it's retired already if the previous dial of the new one failed
+		if !s.conn.retired {
+			s.retire(s.conn)
+		}
+		if err := s.connectLocked(response); err != nil {
+			// the User goes on, the new connection is dialed again in the next call
+			return nil, &rua.ConnectionError{Err: err}
+		}
+		if closed {
+			response.Reconnects++
+		} else {
+			response.Recycled++
+		}
+	}
+	s.conn.requests++
+	s.conn.active++
+	return s.conn, nil
+}
+
+// release finishes a request on the connection got
+func (s *h3Slot) release(conn *h3Conn) {
+	s.mutex.Lock()
+	conn.active--
+	if conn.retired && conn.active == 0 {
+		conn.close()
+	}
+	s.mutex.Unlock()
+}
+
+// retire closes the connection replaced once the requests on it finish
+func (s *h3Slot) retire(conn *h3Conn) {
+	conn.retired = true
+	if conn.active == 0 {
+		conn.close()
+	}
+}
+
+// h3User sends the requests one after another on its streams of the shared connection
+type h3User struct {
+	slot *h3Slot
+	// dials are the connections dialed but not reported in a Response yet
+	dials           []rua.Dial
+	request         *http.Request
+	body            []byte
+	timeout         time.Duration
+	captureResponse bool
+	// buffer is the reusable buffer for the captured body
+	buffer bytes.Buffer
+}
+
+func (u *h3User) DoStaticRequest(response *rua.Response) (err error) {
+	response.Reconnects = 0
+	response.Recycled = 0
+	response.Interim = 0
+	// the connection dialed in CreateUser is reported with the first response
+	response.Dials = append(response.Dials[:0], u.dials...)
+	u.dials = nil
+	conn, err := u.slot.get(response)
+	if err != nil {
+		return err
+	}
+	defer u.slot.release(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+	request := u.request.WithContext(ctx)
+	if u.body != nil {
+		request.Body = ioutil.NopCloser(bytes.NewReader(u.body))
+	}
+	resp, err := conn.client.RoundTrip(request)
+	if err != nil {
+		return u.failed(ctx, conn, err)
+	}
+	response.StatusCode = resp.StatusCode
+	var n int64
+	if u.captureResponse {
+		response.ResetCaptured()
+		for name, values := range resp.Header {
+			response.Headers[name] = append(response.Headers[name], values...)
+		}
+		u.buffer.Reset()
+		n, err = u.buffer.ReadFrom(resp.Body)
+		response.Body = u.buffer.Bytes()
+	} else {
+		// only the body is counted as the headers are decoded by http3
+		n, err = io.Copy(ioutil.Discard, resp.Body)
+	}
+	resp.Body.Close()
+	if err != nil {
+		return u.failed(ctx, conn, err)
+	}
+	response.Size = int(n)
+	return nil
+}
+
+// failed returns the error of the request, the stream reset by the server on a live connection is a ProtocolError,
+// while the failure of the connection is a ConnectionError so that the next request dials a new one
+func (u *h3User) failed(ctx context.Context, conn *h3Conn, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errH3Timeout
+	}
+	if conn.conn.Context().Err() != nil {
+		return &rua.ConnectionError{Err: err}
+	}
+	var h3Error *http3.Error
+	if errors.As(err, &h3Error) && h3Error.Remote {
+		return &rua.ProtocolError{Kind: "RESET_STREAM " + h3Error.ErrorCode.String(), Err: err}
+	}
+	return err
+}
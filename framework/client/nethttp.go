@@ -1,7 +1,8 @@
 package client
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	rua "github.com/taoxinyi/rua/framework"
 	"io"
 	"io/ioutil"
@@ -10,8 +11,12 @@ import (
 
 // netHttpClient uses net.Http.Client for the requests
 type netHttpClient struct {
-	client  *http.Client
-	request *http.Request
+	client          *http.Client
+	dialer          *dialer
+	request         *http.Request
+	captureResponse bool
+	// noKeepAlive is whether each User reports the connections dialed for its own requests
+	noKeepAlive bool
 }
 
 // NewNetHttpClient returns a new netHttpClient
@@ -25,25 +30,59 @@ func (c *netHttpClient) Name() string {
 }
 
 func (c *netHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if err = checkNoRecycling("net", config); err != nil {
+		return err
+	}
+	tlsConfig, err := config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	dialer, err := newDialer(config)
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: config.Connections,
+		TLSClientConfig:     tlsConfig,
+		DialContext:         dialer.dialContext,
+		DisableKeepAlives:   config.NoKeepAlive,
+	}
+	if dialer.forward {
+		// the requests are sent to the http proxy with the absolute URL, otherwise the dialer tunnels through it
+		transport.Proxy = http.ProxyURL(dialer.proxy)
+	}
 	client := &http.Client{
-		Timeout: config.Timeout,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: config.Connections,
-			TLSClientConfig:     &tls.Config{},
-		}}
+		Timeout:   config.Timeout,
+		Transport: transport,
+	}
 	c.client = client
+	c.dialer = dialer
 	c.request = request.HttpRequest
+	c.captureResponse = config.CaptureResponse
+	c.noKeepAlive = config.NoKeepAlive
 	return nil
 }
 
 func (c *netHttpClient) CreateUser() (rua.User, error) {
-	return &netHttpUser{client: c.client, request: c.request}, nil
+	user := &netHttpUser{client: c.client, dials: &c.dialer.log, request: c.request, captureResponse: c.captureResponse}
+	if c.noKeepAlive {
+		// the connection of each request is reported with its response, so that its latency excludes the connecting
+		user.dials = &dialLog{}
+		user.request = c.request.WithContext(context.WithValue(context.Background(), dialLogKey{}, user.dials))
+	}
+	return user, nil
 }
 
 // a netHttpUser just grab a connection from the http.Client and send a requests, and wait for a response
 type netHttpUser struct {
-	client  *http.Client
-	request *http.Request
+	client *http.Client
+	// dials is shared by all the Users unless noKeepAlive, any of them reports the connections dialed by the
+	// http.Client
+	dials           *dialLog
+	request         *http.Request
+	captureResponse bool
+	// body is the reusable buffer for the captured body
+	body bytes.Buffer
 }
 
 func (u *netHttpUser) DoStaticRequest(response *rua.Response) (err error) {
@@ -51,10 +90,22 @@ func (u *netHttpUser) DoStaticRequest(response *rua.Response) (err error) {
 	if err != nil {
 		return err
 	}
+	response.Dials = u.dials.take(response.Dials[:0])
 	response.StatusCode = resp.StatusCode
-	// not accurate, only calculated body
-	// discard the body
-	n, err := io.Copy(ioutil.Discard, resp.Body)
+	var n int64
+	if u.captureResponse {
+		response.ResetCaptured()
+		for name, values := range resp.Header {
+			response.Headers[name] = append(response.Headers[name], values...)
+		}
+		u.body.Reset()
+		n, err = u.body.ReadFrom(resp.Body)
+		response.Body = u.body.Bytes()
+	} else {
+		// not accurate, only calculated body
+		// discard the body
+		n, err = io.Copy(ioutil.Discard, resp.Body)
+	}
 	if err != nil {
 		return err
 	}
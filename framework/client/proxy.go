@@ -0,0 +1,212 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// the schemes of the proxy URLs, socks5h resolves the host name by the proxy instead of locally
+const (
+	proxyHTTP    = "http"
+	proxySOCKS5  = "socks5"
+	proxySOCKS5H = "socks5h"
+)
+
+// the bytes of the SOCKS5 protocol, see RFC 1928 and RFC 1929
+const (
+	socks5Version         = 5
+	socks5AuthNone        = 0
+	socks5AuthPassword    = 2
+	socks5PasswordVersion = 1
+	socks5CommandConnect  = 1
+	socks5AddrIPv4        = 1
+	socks5AddrDomain      = 3
+	socks5AddrIPv6        = 4
+	socks5Succeeded       = 0
+)
+
+// parseProxy parses and checks the proxy URL
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case proxyHTTP, proxySOCKS5, proxySOCKS5H:
+	default:
+		return nil, fmt.Errorf("proxy scheme must be one of http, socks5, socks5h, got %s", u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("proxy port is required, got %s", proxy)
+	}
+	return u, nil
+}
+
+// proxyAuthorization returns the value of the Proxy-Authorization header for the user of the proxy URL
+func proxyAuthorization(proxy *url.URL) string {
+	password, _ := proxy.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password))
+}
+
+// httpConnect asks the http proxy to create a tunnel to the target host:port with CONNECT
+func httpConnect(conn net.Conn, proxy *url.URL, target string) error {
+	request := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if proxy.User != nil {
+		request += "Proxy-Authorization: " + proxyAuthorization(proxy) + "\r\n"
+	}
+	_, err := io.WriteString(conn, request+"\r\n")
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy CONNECT %s failed: %s", target, response.Status)
+	}
+	if reader.Buffered() > 0 {
+		// the target never speaks first, so the bytes after the response are unexpected
+		return errors.New("proxy sent unexpected bytes after CONNECT")
+	}
+	return nil
+}
+
+// socks5Connect asks the SOCKS5 proxy to create a tunnel to the target host:port, the host is resolved locally before
+// the deadline unless it's socks5h
+func socks5Connect(conn net.Conn, proxy *url.URL, target string, deadline time.Time) error {
+	host, portString, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	// negotiate the authentication method
+	method := byte(socks5AuthNone)
+	if proxy.User != nil {
+		method = socks5AuthPassword
+	}
+	_, err = conn.Write([]byte{socks5Version, 1, method})
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[0] != socks5Version || reply[1] != method {
+		return errors.New("SOCKS5 proxy rejected the authentication method")
+	}
+	if method == socks5AuthPassword {
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		// the lengths are in one byte
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username or password longer than 255 bytes")
+		}
+		request := []byte{socks5PasswordVersion, byte(len(username))}
+		request = append(request, username...)
+		request = append(request, byte(len(password)))
+		request = append(request, password...)
+		_, err = conn.Write(request)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, reply)
+		if err != nil {
+			return err
+		}
+		if reply[1] != socks5Succeeded {
+			return errors.New("SOCKS5 proxy rejected the username and password")
+		}
+	}
+
+	// connect to the target
+	request := []byte{socks5Version, socks5CommandConnect, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == proxySOCKS5 {
+		// resolve locally, only socks5h leaves it to the proxy
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		cancel()
+		if err != nil {
+			return err
+		}
+		ip = addrs[0].IP
+	}
+	switch {
+	case ip == nil && len(host) > 255:
+		return errors.New("SOCKS5 host name longer than 255 bytes")
+	case ip == nil:
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	case ip.To4() != nil:
+		request = append(request, socks5AddrIPv4)
+		request = append(request, ip.To4()...)
+	default:
+		request = append(request, socks5AddrIPv6)
+		request = append(request, ip.To16()...)
+	}
+	// the port is in network byte order
+	request = append(request, byte(port>>8), byte(port))
+	_, err = conn.Write(request)
+	if err != nil {
+		return err
+	}
+	// the reply is version, status, reserved, the address type, the bound address and port
+	reply = make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[1] != socks5Succeeded {
+		return fmt.Errorf("SOCKS5 proxy CONNECT %s failed with status %d", target, reply[1])
+	}
+	var addrLen int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		_, err = io.ReadFull(conn, reply[:1])
+		if err != nil {
+			return err
+		}
+		addrLen = int(reply[0])
+	default:
+		return fmt.Errorf("SOCKS5 proxy replied with an unknown address type %d", reply[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, addrLen+2))
+	return err
+}
+
+// forwardRequest rewrites the raw request for the http proxy, the request target becomes the absolute URL
+// and the Proxy-Authorization header is added if the proxy URL has a user
+func forwardRequest(raw []byte, target *url.URL, proxy *url.URL) []byte {
+	lineEnd := bytes.Index(raw, []byte("\r\n"))
+	first := bytes.IndexByte(raw[:lineEnd], ' ')
+	var forwarded []byte
+	forwarded = append(forwarded, raw[:first+1]...)
+	forwarded = append(forwarded, target.Scheme+"://"+target.Host...)
+	forwarded = append(forwarded, raw[first+1:lineEnd+2]...)
+	if proxy.User != nil {
+		forwarded = append(forwarded, "Proxy-Authorization: "+proxyAuthorization(proxy)+"\r\n"...)
+	}
+	return append(forwarded, raw[lineEnd+2:]...)
+}
@@ -6,16 +6,17 @@ import (
 	"errors"
 	"fmt"
 	rua "github.com/taoxinyi/rua/framework"
+	"io"
 	"net"
+	"net/http"
 	"net/url"
+	"syscall"
 	"time"
 )
 
-// the bytes for essential http parsing
-const bCr byte = '\r'
-
-var bCrlfCrlf = []byte("\r\n\r\n")
-var bContentLength = []byte("Content-Length")
+// errClosedBeforeResponse is returned if the connection is closed before any byte of the response is received
+// e.g. the server closed an idle keep-alive connection, so the request can be retried on a new connection
+var errClosedBeforeResponse = errors.New("connection closed before the response")
 
 // rawHttpClient direct operates on TCP connections and parse TCP data from net.Conn for Http requests
 type rawHttpClient struct {
@@ -23,6 +24,20 @@ type rawHttpClient struct {
 	maxResponseSize int
 	requestBytes    []byte
 	timeout         time.Duration
+	captureResponse bool
+	pipeline        int
+	// head is whether the request method is HEAD, so the responses have no body
+	head bool
+	// dialer dials the host:port of the URL or the Unix domain socket, useTLS is true for https
+	dialer    *dialer
+	useTLS    bool
+	tlsConfig *tls.Config
+	// maxRequests is the number of requests sent on a connection before it's replaced, 0 for no limit
+	// maxAge is the time a connection is used before it's replaced, 0 for no limit
+	maxRequests int
+	maxAge      time.Duration
+	// noKeepAlive is whether every request is sent on a new connection
+	noKeepAlive bool
 }
 
 // NewRawHttpClient returns a new rawHttpClient
@@ -38,72 +53,240 @@ func (c *rawHttpClient) Name() string {
 func (c *rawHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
 	c.urlString = config.RequestConfig.URL
 	c.maxResponseSize = config.RecvBufSize
-	c.requestBytes = request.RawBytes
+	// all pipelined requests are written at once
+	c.requestBytes = bytes.Repeat(request.RawBytes, config.Pipeline)
+	c.pipeline = config.Pipeline
 	c.timeout = config.Timeout
-	return nil
-}
+	c.captureResponse = config.CaptureResponse
+	c.head = config.RequestConfig.Method == http.MethodHead
 
-// CreateUser in rawHttpClient creates a TCP connection
-func (c *rawHttpClient) CreateUser() (rua.User, error) {
 	u, err := url.Parse(c.urlString)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	hostname := u.Hostname()
-	port := u.Port()
-	if port == "" {
-		port = u.Scheme
+	c.dialer, err = newDialer(config)
+	if err != nil {
+		return err
 	}
-	address := fmt.Sprintf("%s:%s", hostname, port)
-	var conn net.Conn
-	if u.Scheme == "http" {
-		conn, err = net.DialTimeout("tcp", address, c.timeout)
-
-	} else {
-		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: c.timeout}, "tcp", address, &tls.Config{})
+	if c.dialer.forward {
+		// the requests are sent to the http proxy with the absolute URL
+		c.requestBytes = bytes.Repeat(forwardRequest(request.RawBytes, u, c.dialer.proxy), config.Pipeline)
 	}
+	c.useTLS = u.Scheme != "http"
+	c.tlsConfig, err = config.TLS.ClientConfig()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = u.Hostname()
+	}
+	c.maxRequests = config.MaxRequestsPerConn
+	if handshakeEvery := config.TLS.HandshakeEvery; handshakeEvery > 0 && (c.maxRequests == 0 || handshakeEvery < c.maxRequests) {
+		c.maxRequests = handshakeEvery
+	}
+	c.maxAge = config.MaxConnAge
+	c.noKeepAlive = config.NoKeepAlive
+	return nil
+}
+
+// dial creates a new TCP connection, or a TLS connection for https with the handshake measured
+func (c *rawHttpClient) dial() (conn net.Conn, dial rua.Dial, err error) {
+	if !c.useTLS {
+		return c.dialer.dial()
+	}
+	return c.dialer.dialTLS(c.tlsConfig)
+}
+
+// CreateUser in rawHttpClient creates a TCP connection, unless every request is sent on a new connection
+func (c *rawHttpClient) CreateUser() (rua.User, error) {
+	var conn net.Conn
+	var dials []rua.Dial
+	if !c.noKeepAlive {
+		var dial rua.Dial
+		var err error
+		conn, dial, err = c.dial()
+		if err != nil {
+			return nil, err
+		}
+		dials = append(dials, dial)
 	}
 	return &rawHttpUser{
-		conn:         conn,
-		requestBytes: c.requestBytes,
-		timeout:      c.timeout,
-		rawResponse:  RawResponse{rawBytes: make([]byte, c.maxResponseSize, c.maxResponseSize)},
+		conn:            conn,
+		dial:            c.dial,
+		dials:           dials,
+		connected:       time.Now(),
+		maxRequests:     c.maxRequests,
+		maxAge:          c.maxAge,
+		noKeepAlive:     c.noKeepAlive,
+		requestBytes:    c.requestBytes,
+		timeout:         c.timeout,
+		rawResponse:     RawResponse{rawBytes: make([]byte, c.maxResponseSize, c.maxResponseSize), head: c.head},
+		captureResponse: c.captureResponse,
+		pipeline:        c.pipeline,
 	}, nil
 }
 
 // rawHttpUser contains a dedicated connection, a dedicated bytes for request
 type rawHttpUser struct {
+	// conn is nil if it's closed, a new one will be dialed for the next request
 	conn net.Conn
-	//requestBytes is the unchanged request in bytes
+	dial func() (net.Conn, rua.Dial, error)
+	// dials are the connections dialed but not reported in a Response yet
+	dials []rua.Dial
+	// requests is the number of requests sent on the connection, connected is the time it's dialed
+	// it's replaced after maxRequests requests or maxAge
+	requests    int
+	connected   time.Time
+	maxRequests int
+	maxAge      time.Duration
+	// noKeepAlive is whether the connection is closed after the responses of the requests sent on it
+	noKeepAlive bool
+	//requestBytes is the unchanged request in bytes, repeated pipeline times
 	requestBytes []byte
 	timeout      time.Duration
 	rawResponse  RawResponse
+	// captureResponse is whether to copy the headers and the body to the Response
+	captureResponse bool
+	// pipeline is the number of requests in requestBytes, pending is the number of responses not received yet
+	pipeline int
+	pending  int
+	// rawResponse.rawBytes[start:end] are the bytes received but not parsed yet
+	start int
+	end   int
 }
 
 func (u *rawHttpUser) DoStaticRequest(response *rua.Response) (err error) {
+	response.Reconnects = 0
+	response.Recycled = 0
+	response.Interim = 0
+	// the connection dialed in CreateUser is reported with the first response
+	response.Dials = append(response.Dials[:0], u.dials...)
+	u.dials = nil
+	if u.conn == nil && u.noKeepAlive {
+		err = u.connect(response)
+	} else if u.conn == nil {
+		// the connection was closed after the previous response
+		err = u.reconnect(response)
+	} else if u.expired() {
+		// replace the connection, e.g. so that there is a new handshake
+		u.close()
+		err = u.connect(response)
+		if err == nil {
+			response.Recycled++
+		}
+	}
+	if err != nil {
+		// the connection is dialed again in the next call
+		return &rua.ConnectionError{Err: err}
+	}
+	err = u.doRequest(response)
+	if err == errClosedBeforeResponse {
+		// the server closed the connection, e.g. an idle keep-alive one, retry once on a new connection
+		if err = u.reconnect(response); err != nil {
+			return &rua.ConnectionError{Err: err}
+		}
+		err = u.doRequest(response)
+		if err == errClosedBeforeResponse {
+			// closed again, the next call retries on another connection
+			u.close()
+			return &rua.ConnectionError{Err: err}
+		}
+	}
+	if err != nil {
+		u.close()
+		return err
+	}
+	u.pending--
+	if u.rawResponse.close || (u.noKeepAlive && u.pending == 0) {
+		// the server closes the connection after the response, or it's not kept alive
+		u.close()
+	}
+	return nil
+}
+
+// doRequest writes the requests if all responses of the previous ones are received, then reads one response
+func (u *rawHttpUser) doRequest(response *rua.Response) (err error) {
 	//set deadline
 	deadline := time.Now().Add(u.timeout)
 	err = u.conn.SetReadDeadline(deadline)
 	if err != nil {
-		u.conn.Close()
 		return err
 	}
-	//start write and read
-	_, err = u.write(u.requestBytes)
+	// the responses of the previous pipelined requests are not all received yet
+	response.Pipelined = u.pending > 0
+	if !response.Pipelined {
+		//start write and read
+		_, err = u.write(u.requestBytes)
+		if err != nil {
+			if isConnectionClosed(err) {
+				return errClosedBeforeResponse
+			}
+			return err
+		}
+		u.pending = u.pipeline
+		u.requests += u.pipeline
+	}
+	return u.fillResponse(response)
+}
+
+// checkNoRecycling returns an error if the connections are to be replaced by the config, for the client of the name
+// which doesn't replace them
+func checkNoRecycling(name string, config *rua.LgConfig) error {
+	if config.MaxRequestsPerConn > 0 || config.MaxConnAge > 0 || config.TLS.HandshakeEvery > 0 {
+		return fmt.Errorf("%s doesn't replace the connections, it doesn't support max requests per connection, "+
+			"max connection age or handshake every", name)
+	}
+	return nil
+}
+
+// expired returns whether the connection reached maxRequests or maxAge, it's only replaced once all the responses
+// of the requests sent are received
+func (u *rawHttpUser) expired() bool {
+	if u.pending > 0 {
+		return false
+	}
+	return (u.maxRequests > 0 && u.requests >= u.maxRequests) || (u.maxAge > 0 && time.Since(u.connected) >= u.maxAge)
+}
+
+// reconnect closes the current connection if any, and dials a new one to replace it
+func (u *rawHttpUser) reconnect(response *rua.Response) (err error) {
+	u.close()
+	err = u.connect(response)
 	if err != nil {
-		u.conn.Close()
 		return err
 	}
-	err = u.fillResponse(response)
+	response.Reconnects++
+	return nil
+}
+
+// connect dials a new connection and reports it in the Response
+func (u *rawHttpUser) connect(response *rua.Response) (err error) {
+	conn, dial, err := u.dial()
 	if err != nil {
-		u.conn.Close()
 		return err
 	}
+	u.conn = conn
+	u.requests = 0
+	u.connected = time.Now()
+	response.Dials = append(response.Dials, dial)
 	return nil
 }
 
+// close closes the connection and drops all the pending responses as well as the bytes received
+func (u *rawHttpUser) close() {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+	u.pending = 0
+	u.start, u.end = 0, 0
+}
+
+// isConnectionClosed returns whether the error is caused by the connection closed by the peer
+func isConnectionClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
 // write is used to write bytes b to the underlying net.Conn
 // It will keep writing until all bytes in len(b) is written or error occurs
 func (u *rawHttpUser) write(b []byte) (n int, err error) {
@@ -124,142 +307,85 @@ func (u *rawHttpUser) read(b []byte) (n int, err error) {
 }
 
 // fillResponse will read until one http response is finished, or an error occurs
-// Current implementation will try to read util has first CRLFCRLF, then parse the status line and Headers to get the
+// Current implementation will try to read util has the empty line ending the headers, then parse the status line and Headers to get the
 // content length, then read until all content is received
-// If rawBytes is full but CRLFCRLF is still not encountered (very long headers) it will throw errors so make sure
+// The bytes after the response (from the pipelined responses) are kept in rawBytes[start:end] for the next call
+// If rawBytes is full but the empty line is still not encountered (very long headers) it will throw errors so make sure
 // to increase maxResponseSize
 func (u *rawHttpUser) fillResponse(response *rua.Response) (err error) {
-	rawResponse := u.rawResponse
+	rawResponse := &u.rawResponse
 	b := rawResponse.rawBytes
-	// read once
-	n, err := u.read(b)
+	// move the remaining bytes of the previous read to the beginning, the response always starts from rawBytes[0]
+	n := copy(b, b[u.start:u.end])
+	u.start, u.end = 0, 0
+	// the size of the interim responses skipped and the final one
+	size := 0
+	for {
+		// reset the parser state for a new response
+		rawResponse.ResetState()
+		for !rawResponse.CanStartParse(n) {
+			if n == len(b) {
+				// the empty line is not encountered but the buffer is full
+				return errors.New(fmt.Sprintf("Receiver buffer full, didn't encounter the end of headers after %d bytes", len(b)))
+			}
+			newRead, err := u.read(b[n:])
+			if err != nil {
+				if n == 0 && size == 0 && isConnectionClosed(err) {
+					return errClosedBeforeResponse
+				}
+				return err
+			}
+			n += newRead
+		}
+		// the empty line is encountered
+		err = rawResponse.Parse()
+		if err != nil {
+			return err
+		}
+		if !rawResponse.interim {
+			break
+		}
+		// an interim response has no body, the final response follows it
+		response.Interim++
+		size += rawResponse.bodyStart
+		n = copy(b, b[rawResponse.bodyStart:n])
+	}
+	var body *[]byte
+	if u.captureResponse {
+		response.ResetCaptured()
+		rawResponse.FillHeaders(response.Headers)
+		body = &response.Body
+	}
+	size += rawResponse.bodyStart
+	consumed, complete, err := rawResponse.ConsumeBody(b[rawResponse.bodyStart:n], body)
 	if err != nil {
 		return err
 	}
-	// reset the parser state for a new response
-	rawResponse.ResetState()
-	for !rawResponse.CanStartParse(n) {
-		if n == len(b) {
-			// CRLFCRLF is not encountered but the buffer is full
-			return errors.New(fmt.Sprintf("Receiver buffer full, didn't encounter CRLFCRLF after %d bytes", len(b)))
+	size += consumed
+	end := rawResponse.bodyStart + consumed
+	for !complete {
+		// keep reading the body to the buffer but it will not be used unless captureResponse is true
+		// since we already get statusCode and content length
+		n, err = u.read(b)
+		if err == io.EOF && rawResponse.untilEOF {
+			// the body ends when the connection is closed
+			end = 0
+			break
 		}
-		newRead, err := u.read(b[n:])
 		if err != nil {
 			return err
 		}
-		n += newRead
-	}
-	// CRLFCRLF is encountered
-	rawResponse.Parse()
-	for !rawResponse.IsBodyComplete(n) {
-		// keep reading the body to the buffer but it will not be used
-		// since we already get statusCode and content length
-		newRead, err := u.read(b)
+		consumed, complete, err = rawResponse.ConsumeBody(b[:n], body)
 		if err != nil {
 			return err
 		}
-		n += newRead
+		size += consumed
+		end = consumed
 	}
+	// keep the bytes of the next response
+	u.start, u.end = end, n
 	// update the response size
-	response.Size = n
+	response.Size = size
 	response.StatusCode = rawResponse.StatusCode
 	return nil
 }
-
-// Response contains a status code, the size, content-length as well as the underlying bytes
-type RawResponse struct {
-	StatusCode    int
-	ContentLength int
-	// the underlying rawBytes buffer
-	rawBytes []byte
-	//bodyStart is the index of rawBytes indicating where the body starts
-	bodyStart int
-	// lastIndex is the last possible index that can start with bCrlfCrlf
-	lastIndex int
-}
-
-// CanStartParse returns whether the rawBytes given the length, contains CRLFCRLF so it can be parsed
-// once CRLFCRLF is found, it will record the location for where the body starts
-func (r *RawResponse) CanStartParse(length int) bool {
-	i := bytes.Index(r.rawBytes[r.lastIndex:length], bCrlfCrlf)
-	if i == -1 {
-		// no CRLFCRLF, move cursor ahead of 3 bytes in case CR,LF,CR are already received
-		r.lastIndex = intMax(r.lastIndex-3, 0)
-		return false
-	}
-	// CRLFCRLF found, body start is the offset from rawBytes
-	r.bodyStart = r.lastIndex + i + 4
-	return true
-}
-
-// Parse is used to parse the underlying rawBytes to get StatusCode and ContentLength
-func (r *RawResponse) Parse() {
-	//rua 200 OK status from [9:12),
-	r.StatusCode = parseStatusCode(r.rawBytes[9:12])
-	headerStart := bytes.IndexByte(r.rawBytes[12:], bCr) + 2
-	r.updateContentLengthFromHeaders(r.rawBytes[12+headerStart : r.bodyStart-2])
-}
-
-// updateContentLengthFromHeaders is used to parse the headers given the header bytes, and update the ContentLength of the Response
-func (r *RawResponse) updateContentLengthFromHeaders(b []byte) {
-	for i := bytes.IndexByte(b, bCr); i != -1; i= bytes.IndexByte(b, bCr){
-		line := b[:i]
-		sep := bytes.IndexByte(b, ':')
-		name := line[:sep]
-		b = b[i+2:]
-		// always assume "Content-Length", case sensitive
-		if bytes.Equal(name, bContentLength) {
-			// always assume Header is the format "Name: Value"
-			value := line[sep+2:]
-			// find content length
-			r.ContentLength = atoi(value)
-			return
-		}
-	}
-	// no content length in the header, default to 0
-	r.ContentLength = 0
-}
-
-// IsBodyComplete is used to return whether rawBytes is a complete Response given the total number of the bytes read
-func (r *RawResponse) IsBodyComplete(length int) bool {
-	return length-r.bodyStart == r.ContentLength
-}
-
-// ResetState is used to reset the Response state so it can be used for parsing a new one
-func (r *RawResponse) ResetState() {
-	r.lastIndex = 0
-}
-
-// intMax return the max value of two ints
-func intMax(x, y int) int {
-	if x > y {
-		return x
-	}
-	return y
-}
-
-// parseStatusCode assuming status code is always 3 digit
-func parseStatusCode(b []byte) int {
-	return int(b[0])*100 + int(b[1])*10 + int(b[2]) - 5328
-}
-
-// faster atoi assuming content length is at most 4 digits in most cases
-func atoi(b []byte) int {
-	l := len(b)
-	switch l {
-	case 1:
-		return int(b[0]) - 48
-	case 2:
-		return int(b[0])*10 + int(b[1]) - 528
-	case 3:
-		return int(b[0])*100 + int(b[1])*10 + int(b[2]) - 5328
-	case 4:
-		return int(b[0])*1000 + int(b[1])*100 + int(b[2])*10 + int(b[3]) - 53328
-	default:
-		res := 0
-		for i := 0; i < l; i++ {
-			res = 10*res + int(b[i]) - 48
-		}
-		return res
-	}
-}
\ No newline at end of file
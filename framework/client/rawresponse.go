@@ -0,0 +1,507 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+)
+
+// the bytes for essential http parsing
+const bCr byte = '\r'
+const bLf byte = '\n'
+
+var bHttpVersionPrefix = []byte("HTTP/")
+var bContentLength = []byte("Content-Length")
+var bTransferEncoding = []byte("Transfer-Encoding")
+var bChunked = []byte("chunked")
+var bConnection = []byte("Connection")
+var bClose = []byte("close")
+var bKeepAlive = []byte("keep-alive")
+
+// the headers that matter for parsing, the others are ignored
+const (
+	headerOther = iota
+	headerContentLength
+	headerTransferEncoding
+	headerConnection
+)
+
+// the states of parsing a chunked body
+const (
+	chunkSize = iota
+	chunkExtension
+	chunkSizeLf
+	chunkData
+	chunkDataCr
+	chunkDataLf
+	chunkTrailerStart
+	chunkTrailer
+	chunkTrailerLf
+	chunkTrailerEndLf
+	chunkDone
+)
+
+// maxChunkSizeDigits is the max number of hex digits of a chunk size, so that it will not overflow
+const maxChunkSizeDigits = 15
+
+// maxContentLengthDigits is the max number of digits of the content length, so that it will not overflow
+const maxContentLengthDigits = 18
+
+// ParseError is returned if the response is malformed, instead of panicking or guessing a wrong body length
+type ParseError struct {
+	// Reason describes what is malformed
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return "malformed HTTP response: " + e.Reason
+}
+
+func parseError(format string, a ...interface{}) *ParseError {
+	return &ParseError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// Response contains a status code, the size, content-length as well as the underlying bytes
+type RawResponse struct {
+	StatusCode int
+	// ContentLength is -1 if the body ends when the connection is closed, or 0 if the response has no body
+	ContentLength int
+	// ProtoMajor and ProtoMinor are the HTTP version of the response, e.g. 1 and 0 for HTTP/1.0
+	ProtoMajor int
+	ProtoMinor int
+	// the underlying rawBytes buffer
+	rawBytes []byte
+	//headerStart is the index of rawBytes indicating where the headers start
+	headerStart int
+	//bodyStart is the index of rawBytes indicating where the body starts
+	bodyStart int
+	// lastIndex is the index of rawBytes to continue searching for the end of the headers
+	lastIndex int
+	// remaining is the number of body bytes not consumed yet, or of the current chunk if chunked
+	remaining int
+	// chunked is whether the body is in chunked transfer coding
+	chunked bool
+	// close is whether the connection will be closed after the response
+	close bool
+	// untilEOF is whether the body ends when the connection is closed, since neither chunked nor length is given
+	untilEOF bool
+	// head is whether the request method is HEAD, so the response has no body whatever the headers say
+	head bool
+	// interim is whether it's a 1xx interim response, which is followed by the final response
+	interim bool
+	// chunkState is the state of parsing a chunked body, chunkSizeDigits is the digits of the chunk size parsed
+	chunkState      int
+	chunkSizeDigits int
+}
+
+// CanStartParse returns whether the rawBytes given the length contains the empty line ending the headers,
+// so it can be parsed. Lines are ended by CRLF, or LF only. Once the empty line is found,
+// it will record the location for where the body starts
+func (r *RawResponse) CanStartParse(length int) bool {
+	b := r.rawBytes[:length]
+	for i := r.lastIndex; ; {
+		j := bytes.IndexByte(b[i:], bLf)
+		if j == -1 {
+			r.lastIndex = length
+			return false
+		}
+		i += j + 1
+		// a line is ended, check whether the next line is empty
+		if i < length && b[i] == bLf {
+			r.bodyStart = i + 1
+			return true
+		}
+		if i+1 < length && b[i] == bCr && b[i+1] == bLf {
+			r.bodyStart = i + 2
+			return true
+		}
+		if i+1 >= length {
+			// not enough bytes to know, search from the LF again next time
+			r.lastIndex = i - 1
+			return false
+		}
+	}
+}
+
+// Parse is used to parse the underlying rawBytes to get StatusCode and ContentLength, as well as how the body ends
+// It should be called only after CanStartParse returns true
+// Header names are case insensitive, the whitespaces around the values are ignored and obsolete line folding is
+// supported. A ParseError is returned if the response is malformed
+func (r *RawResponse) Parse() error {
+	line, rest := nextLine(r.rawBytes[:r.bodyStart])
+	err := r.parseStatusLine(line)
+	if err != nil {
+		return err
+	}
+	r.headerStart = r.bodyStart - len(rest)
+
+	r.ContentLength = 0
+	r.chunked = false
+	hasContentLength := false
+	connectionClose := false
+	keepAlive := false
+	header := -1
+	for line, rest = nextLine(rest); len(line) > 0; line, rest = nextLine(rest) {
+		var value []byte
+		if line[0] == ' ' || line[0] == '\t' {
+			// obsolete line folding, the value of the previous header continues
+			if header == -1 {
+				return parseError("the first header line starts with whitespace")
+			}
+			value = trimWhitespace(line)
+		} else {
+			sep := bytes.IndexByte(line, ':')
+			if sep <= 0 {
+				return parseError("invalid header line %q", line)
+			}
+			header = headerKind(trimWhitespace(line[:sep]))
+			value = trimWhitespace(line[sep+1:])
+		}
+		switch header {
+		case headerContentLength:
+			contentLength, ok := parseContentLength(value)
+			if !ok {
+				return parseError("invalid Content-Length %q", value)
+			}
+			if hasContentLength && contentLength != r.ContentLength {
+				return parseError("conflicting Content-Length %d and %d", r.ContentLength, contentLength)
+			}
+			r.ContentLength = contentLength
+			hasContentLength = true
+		case headerTransferEncoding:
+			// chunked is always the last transfer coding if present
+			r.chunked = bytes.EqualFold(lastToken(value), bChunked)
+		case headerConnection:
+			for token, tokens := nextToken(value); len(token) > 0 || len(tokens) > 0; token, tokens = nextToken(tokens) {
+				connectionClose = connectionClose || bytes.EqualFold(token, bClose)
+				keepAlive = keepAlive || bytes.EqualFold(token, bKeepAlive)
+			}
+		}
+	}
+
+	// HTTP/1.1 is persistent by default, while HTTP/1.0 is not unless keep-alive
+	r.close = connectionClose || (r.ProtoMajor == 1 && r.ProtoMinor == 0 && !keepAlive)
+	// 101 Switching Protocols is the final response, the connection is no longer HTTP after it
+	r.interim = r.StatusCode < 200 && r.StatusCode != http.StatusSwitchingProtocols
+	if r.StatusCode == http.StatusSwitchingProtocols {
+		r.close = true
+	}
+	if r.head || r.StatusCode < 200 || r.StatusCode == http.StatusNoContent || r.StatusCode == http.StatusNotModified {
+		// the responses that never have a body, the Content-Length of a HEAD or 304 response is of the would-be body
+		r.chunked = false
+		r.untilEOF = false
+		r.ContentLength = 0
+		r.remaining = 0
+		return nil
+	}
+	// without both, the body is until the connection is closed
+	r.untilEOF = !r.chunked && !hasContentLength
+	if r.untilEOF {
+		r.close = true
+		r.ContentLength = -1
+	}
+	r.remaining = r.ContentLength
+	if r.chunked {
+		r.remaining = 0
+		r.chunkState = chunkSize
+		r.chunkSizeDigits = 0
+	}
+	return nil
+}
+
+// parseStatusLine parses the status line like "HTTP/1.1 200 OK", the reason phrase is optional
+func (r *RawResponse) parseStatusLine(line []byte) error {
+	if len(line) < 12 || !bytes.HasPrefix(line, bHttpVersionPrefix) ||
+		!isDigit(line[5]) || line[6] != '.' || !isDigit(line[7]) || line[8] != ' ' {
+		return parseError("invalid status line %q", line)
+	}
+	r.ProtoMajor = int(line[5] - '0')
+	r.ProtoMinor = int(line[7] - '0')
+	// tolerate extra whitespaces before the status code
+	code := bytes.TrimLeft(line[9:], " ")
+	if len(code) < 3 || !isDigit(code[0]) || !isDigit(code[1]) || !isDigit(code[2]) ||
+		(len(code) > 3 && code[3] != ' ' && code[3] != '\t') {
+		return parseError("invalid status code in status line %q", line)
+	}
+	r.StatusCode = parseStatusCode(code[:3])
+	if r.StatusCode < 100 {
+		return parseError("invalid status code %d", r.StatusCode)
+	}
+	return nil
+}
+
+// FillHeaders adds all the headers to the http.Header, it should be called after Parse returns no error
+// unlike Parse, it allocates for each header so it should be used only when the headers are needed
+func (r *RawResponse) FillHeaders(header http.Header) {
+	var name string
+	for line, rest := nextLine(r.rawBytes[r.headerStart:r.bodyStart]); len(line) > 0; line, rest = nextLine(rest) {
+		if line[0] == ' ' || line[0] == '\t' {
+			// obsolete line folding, replaced with a space
+			values := header[name]
+			values[len(values)-1] += " " + string(trimWhitespace(line))
+			continue
+		}
+		sep := bytes.IndexByte(line, ':')
+		name = http.CanonicalHeaderKey(string(trimWhitespace(line[:sep])))
+		header[name] = append(header[name], string(trimWhitespace(line[sep+1:])))
+	}
+}
+
+// ConsumeBody is used to consume the body from the bytes b, which are the bytes received after the previous call
+// It returns the number of bytes belong to the body of this Response, and whether the body is complete
+// the bytes after it belong to the next Response. If body is not nil, the body bytes will be appended to it
+// For a chunked body, only the chunk data without the chunk sizes and trailers will be appended
+func (r *RawResponse) ConsumeBody(b []byte, body *[]byte) (n int, complete bool, err error) {
+	if r.chunked {
+		return r.consumeChunks(b, body)
+	}
+	if r.untilEOF {
+		if body != nil {
+			*body = append(*body, b...)
+		}
+		return len(b), false, nil
+	}
+	n = intMin(len(b), r.remaining)
+	r.remaining -= n
+	if body != nil {
+		*body = append(*body, b[:n]...)
+	}
+	return n, r.remaining == 0, nil
+}
+
+// consumeChunks parses the chunked body incrementally, the chunk data is skipped without being copied
+// unless body is not nil. Lines are ended by CRLF, or LF only
+func (r *RawResponse) consumeChunks(b []byte, body *[]byte) (n int, complete bool, err error) {
+	for n < len(b) && r.chunkState != chunkDone {
+		c := b[n]
+		switch r.chunkState {
+		case chunkSize:
+			if c == bCr || c == bLf || c == ';' || c == ' ' || c == '\t' {
+				if r.chunkSizeDigits == 0 {
+					return n, false, parseError("missing chunk size")
+				}
+				r.chunkState = chunkExtension
+				if c == bCr {
+					r.chunkState = chunkSizeLf
+				} else if c == bLf {
+					r.endChunkSize()
+				}
+				break
+			}
+			digit := unhex(c)
+			if digit < 0 || r.chunkSizeDigits == maxChunkSizeDigits {
+				return n, false, parseError("invalid chunk size byte %q", c)
+			}
+			r.remaining = r.remaining<<4 | digit
+			r.chunkSizeDigits++
+		case chunkExtension:
+			// chunk extensions are ignored
+			if c == bCr {
+				r.chunkState = chunkSizeLf
+			} else if c == bLf {
+				r.endChunkSize()
+			}
+		case chunkSizeLf:
+			if c != bLf {
+				return n, false, parseError("missing LF after chunk size")
+			}
+			r.endChunkSize()
+		case chunkData:
+			data := intMin(len(b)-n, r.remaining)
+			if body != nil {
+				*body = append(*body, b[n:n+data]...)
+			}
+			r.remaining -= data
+			n += data
+			if r.remaining == 0 {
+				r.chunkState = chunkDataCr
+			}
+			continue
+		case chunkDataCr:
+			if c == bLf {
+				r.startChunkSize()
+				break
+			}
+			if c != bCr {
+				return n, false, parseError("missing CRLF after chunk data")
+			}
+			r.chunkState = chunkDataLf
+		case chunkDataLf:
+			if c != bLf {
+				return n, false, parseError("missing CRLF after chunk data")
+			}
+			r.startChunkSize()
+		case chunkTrailerStart:
+			r.chunkState = chunkTrailer
+			if c == bCr {
+				// empty line, the end of the body
+				r.chunkState = chunkTrailerEndLf
+			} else if c == bLf {
+				r.chunkState = chunkDone
+			}
+		case chunkTrailer:
+			// trailers are ignored
+			if c == bCr {
+				r.chunkState = chunkTrailerLf
+			} else if c == bLf {
+				r.chunkState = chunkTrailerStart
+			}
+		case chunkTrailerLf:
+			if c != bLf {
+				return n, false, parseError("missing LF after trailer")
+			}
+			r.chunkState = chunkTrailerStart
+		case chunkTrailerEndLf:
+			if c != bLf {
+				return n, false, parseError("missing LF after trailers")
+			}
+			r.chunkState = chunkDone
+		}
+		n++
+	}
+	return n, r.chunkState == chunkDone, nil
+}
+
+// startChunkSize starts parsing the size of the next chunk
+func (r *RawResponse) startChunkSize() {
+	r.chunkState = chunkSize
+	r.chunkSizeDigits = 0
+}
+
+// endChunkSize is called at the end of the chunk size line, the last chunk is the one with size 0
+func (r *RawResponse) endChunkSize() {
+	r.chunkState = chunkData
+	if r.remaining == 0 {
+		// the last chunk, followed by the trailers
+		r.chunkState = chunkTrailerStart
+	}
+}
+
+// ResetState is used to reset the Response state so it can be used for parsing a new one
+func (r *RawResponse) ResetState() {
+	r.lastIndex = 0
+}
+
+// nextLine returns the first line of b without the CRLF or LF, and the bytes after it
+// the line is empty if it's an empty line or there's no line ending in b
+func nextLine(b []byte) (line []byte, rest []byte) {
+	i := bytes.IndexByte(b, bLf)
+	if i == -1 {
+		return nil, nil
+	}
+	line = b[:i]
+	if len(line) > 0 && line[len(line)-1] == bCr {
+		line = line[:len(line)-1]
+	}
+	return line, b[i+1:]
+}
+
+// nextToken returns the first token of a comma separated list without the whitespaces, and the bytes after it
+func nextToken(b []byte) (token []byte, rest []byte) {
+	i := bytes.IndexByte(b, ',')
+	if i == -1 {
+		return trimWhitespace(b), nil
+	}
+	return trimWhitespace(b[:i]), b[i+1:]
+}
+
+// lastToken returns the last token of a comma separated list without the whitespaces
+func lastToken(b []byte) []byte {
+	return trimWhitespace(b[bytes.LastIndexByte(b, ',')+1:])
+}
+
+// headerKind returns which of the headers that matter for parsing the name is, case insensitive
+func headerKind(name []byte) int {
+	switch {
+	case bytes.EqualFold(name, bContentLength):
+		return headerContentLength
+	case bytes.EqualFold(name, bTransferEncoding):
+		return headerTransferEncoding
+	case bytes.EqualFold(name, bConnection):
+		return headerConnection
+	}
+	return headerOther
+}
+
+// parseContentLength parses the value of Content-Length, a list of the same values like "5, 5" is also accepted
+func parseContentLength(b []byte) (contentLength int, ok bool) {
+	contentLength = -1
+	for token, rest := nextToken(b); len(token) > 0 || len(rest) > 0; token, rest = nextToken(rest) {
+		if len(token) == 0 || len(token) > maxContentLengthDigits {
+			return 0, false
+		}
+		for _, c := range token {
+			if !isDigit(c) {
+				return 0, false
+			}
+		}
+		value := atoi(token)
+		if contentLength != -1 && value != contentLength {
+			return 0, false
+		}
+		contentLength = value
+	}
+	return contentLength, contentLength != -1
+}
+
+// trimWhitespace removes the leading and trailing spaces and tabs
+func trimWhitespace(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// intMin return the min value of two ints
+func intMin(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// unhex returns the value of a hex digit, or -1 if it's not a hex digit
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+// parseStatusCode assuming status code is always 3 digit
+func parseStatusCode(b []byte) int {
+	return int(b[0])*100 + int(b[1])*10 + int(b[2]) - 5328
+}
+
+// faster atoi assuming content length is at most 4 digits in most cases
+func atoi(b []byte) int {
+	l := len(b)
+	switch l {
+	case 1:
+		return int(b[0]) - 48
+	case 2:
+		return int(b[0])*10 + int(b[1]) - 528
+	case 3:
+		return int(b[0])*100 + int(b[1])*10 + int(b[2]) - 5328
+	case 4:
+		return int(b[0])*1000 + int(b[1])*100 + int(b[2])*10 + int(b[3]) - 53328
+	default:
+		res := 0
+		for i := 0; i < l; i++ {
+			res = 10*res + int(b[i]) - 48
+		}
+		return res
+	}
+}
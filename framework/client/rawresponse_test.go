@@ -0,0 +1,242 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+)
+
+// parseHeaders parses the headers of the response, which must end with the empty line
+func parseHeaders(t *testing.T, headers string) (*RawResponse, error) {
+	t.Helper()
+	r := &RawResponse{rawBytes: []byte(headers)}
+	if !r.CanStartParse(len(headers)) {
+		t.Fatalf("CanStartParse(%q) = false, want true", headers)
+	}
+	if r.bodyStart != len(headers) {
+		t.Fatalf("bodyStart of %q = %d, want %d", headers, r.bodyStart, len(headers))
+	}
+	return r, r.Parse()
+}
+
+func TestRawResponseCanStartParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+	}{
+		{"CRLF", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+		{"LF", "HTTP/1.1 200 OK\nContent-Length: 5\n\n"},
+		{"CRLF then LF", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\n"},
+		{"LF then CRLF", "HTTP/1.1 200 OK\nContent-Length: 5\n\r\n"},
+		{"no headers", "HTTP/1.1 204 No Content\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the bytes arrive one by one, followed by the body
+			r := &RawResponse{rawBytes: []byte(tt.headers + "hello")}
+			for length := 1; length < len(tt.headers); length++ {
+				if r.CanStartParse(length) {
+					t.Fatalf("CanStartParse(%d) = true before the empty line", length)
+				}
+			}
+			if !r.CanStartParse(len(tt.headers)) {
+				t.Fatalf("CanStartParse(%d) = false at the empty line", len(tt.headers))
+			}
+			if r.bodyStart != len(tt.headers) {
+				t.Errorf("bodyStart = %d, want %d", r.bodyStart, len(tt.headers))
+			}
+		})
+	}
+}
+
+func TestRawResponseParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       string
+		statusCode    int
+		contentLength int
+		chunked       bool
+		close         bool
+		wantErr       bool
+	}{
+		{name: "CRLF", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", statusCode: 200, contentLength: 5},
+		{name: "LF", headers: "HTTP/1.1 404 Not Found\nContent-Length: 5\n\n", statusCode: 404, contentLength: 5},
+		{name: "no reason phrase", headers: "HTTP/1.1 200\r\nContent-Length: 0\r\n\r\n", statusCode: 200},
+		{name: "case insensitive names", headers: "HTTP/1.1 200 OK\r\ncontent-LENGTH: 7\r\nconnection: CLOSE\r\n\r\n",
+			statusCode: 200, contentLength: 7, close: true},
+		{name: "whitespaces around the value", headers: "HTTP/1.1 200 OK\r\nContent-Length: \t 5 \t\r\n\r\n",
+			statusCode: 200, contentLength: 5},
+		{name: "obs-fold", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: keep-alive,\r\n close\r\n\r\n",
+			statusCode: 200, contentLength: 5, close: true},
+		{name: "obs-fold with tab and LF", headers: "HTTP/1.1 200 OK\nX-Long: a\n\tb\nContent-Length: 5\n\n",
+			statusCode: 200, contentLength: 5},
+		{name: "obs-fold of the first header", headers: "HTTP/1.1 200 OK\r\n folded\r\n\r\n", wantErr: true},
+		{name: "duplicate Content-Length", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\n",
+			statusCode: 200, contentLength: 5},
+		{name: "Content-Length list", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5, 5\r\n\r\n",
+			statusCode: 200, contentLength: 5},
+		{name: "conflicting Content-Length",
+			headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\n", wantErr: true},
+		{name: "conflicting Content-Length list", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5, 6\r\n\r\n", wantErr: true},
+		{name: "negative Content-Length", headers: "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n", wantErr: true},
+		{name: "non-digit Content-Length", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5a\r\n\r\n", wantErr: true},
+		{name: "empty Content-Length", headers: "HTTP/1.1 200 OK\r\nContent-Length:\r\n\r\n", wantErr: true},
+		{name: "overflowing Content-Length",
+			headers: "HTTP/1.1 200 OK\r\nContent-Length: 1234567890123456789\r\n\r\n", wantErr: true},
+		{name: "chunked", headers: "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, Chunked\r\n\r\n",
+			statusCode: 200, chunked: true},
+		{name: "HTTP/1.0 closes by default", headers: "HTTP/1.0 200 OK\r\nContent-Length: 5\r\n\r\n",
+			statusCode: 200, contentLength: 5, close: true},
+		{name: "HTTP/1.0 keep-alive", headers: "HTTP/1.0 200 OK\r\nContent-Length: 5\r\nConnection: Keep-Alive\r\n\r\n",
+			statusCode: 200, contentLength: 5},
+		{name: "HTTP/1.0 until EOF", headers: "HTTP/1.0 200 OK\r\nConnection: keep-alive\r\n\r\n",
+			statusCode: 200, contentLength: -1, close: true},
+		{name: "HTTP/1.1 close", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: foo, close\r\n\r\n",
+			statusCode: 200, contentLength: 5, close: true},
+		{name: "HTTP/1.1 until EOF", headers: "HTTP/1.1 200 OK\r\n\r\n", statusCode: 200, contentLength: -1, close: true},
+		{name: "no body", headers: "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n", statusCode: 304},
+		{name: "invalid header line", headers: "HTTP/1.1 200 OK\r\nno colon\r\n\r\n", wantErr: true},
+		{name: "invalid status line", headers: "HTTP/1.1 2xx OK\r\n\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseHeaders(t, tt.headers)
+			if tt.wantErr {
+				if _, ok := err.(*ParseError); !ok {
+					t.Fatalf("Parse() = %v, want a ParseError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() = %v", err)
+			}
+			if r.StatusCode != tt.statusCode {
+				t.Errorf("StatusCode = %d, want %d", r.StatusCode, tt.statusCode)
+			}
+			if r.ContentLength != tt.contentLength {
+				t.Errorf("ContentLength = %d, want %d", r.ContentLength, tt.contentLength)
+			}
+			if r.chunked != tt.chunked {
+				t.Errorf("chunked = %v, want %v", r.chunked, tt.chunked)
+			}
+			if r.close != tt.close {
+				t.Errorf("close = %v, want %v", r.close, tt.close)
+			}
+		})
+	}
+}
+
+func TestRawResponseFillHeaders(t *testing.T) {
+	r, err := parseHeaders(t, "HTTP/1.1 200 OK\r\nX-Folded: a\r\n \t b\r\nx-multi: 1\nX-Multi:  2 \r\nContent-Length: 0\r\n\r\n")
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	header := http.Header{}
+	r.FillHeaders(header)
+	if got := header["X-Folded"]; len(got) != 1 || got[0] != "a b" {
+		t.Errorf("X-Folded = %q, want [a b]", got)
+	}
+	if got := header["X-Multi"]; len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("X-Multi = %q, want [1 2]", got)
+	}
+}
+
+// consumeBody feeds the bytes to ConsumeBody in pieces of the size, as if they're read one piece at a time
+// it returns the body and the number of bytes consumed once the body is complete or the error occurs
+func consumeBody(r *RawResponse, b []byte, size int) (body []byte, n int, complete bool, err error) {
+	body = []byte{}
+	for start := 0; start < len(b); start += size {
+		piece := b[start:intMin(start+size, len(b))]
+		m, complete, err := r.ConsumeBody(piece, &body)
+		n += m
+		if err != nil || complete {
+			return body, n, complete, err
+		}
+	}
+	return body, n, false, nil
+}
+
+func TestRawResponseConsumeChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunked string
+		body    string
+		wantErr bool
+	}{
+		{name: "CRLF", chunked: "5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n", body: "hello world"},
+		{name: "LF", chunked: "5\nhello\n6\n world\n0\n\n", body: "hello world"},
+		{name: "hex sizes", chunked: "a\r\n0123456789\r\nA\r\n0123456789\r\n0\r\n\r\n", body: "01234567890123456789"},
+		{name: "zero padded size", chunked: "0005\r\nhello\r\n000\r\n\r\n", body: "hello"},
+		{name: "extensions", chunked: "5;name=value\r\nhello\r\n6 ; a=\"b;c\"\r\n world\r\n0;last\r\n\r\n",
+			body: "hello world"},
+		{name: "trailers", chunked: "5\r\nhello\r\n0\r\nX-Checksum: 123\r\nX-Other: 4\r\n\r\n", body: "hello"},
+		{name: "trailers with LF", chunked: "5\nhello\n0\nX-Checksum: 123\n\n", body: "hello"},
+		{name: "empty", chunked: "0\r\n\r\n", body: ""},
+		{name: "missing size", chunked: "\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "invalid size", chunked: "5x\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "overflowing size", chunked: "1000000000000000\r\nhello\r\n0\r\n\r\n", wantErr: true},
+		{name: "missing CRLF after data", chunked: "5\r\nhello!\r\n0\r\n\r\n", wantErr: true},
+		{name: "missing LF after size", chunked: "5\rhello\r\n0\r\n\r\n", wantErr: true},
+	}
+	// the bytes of the next response after the body are not consumed
+	const next = "HTTP/1.1 200 OK\r\n\r\n"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every split of the reads, so that the sizes, the CRLFs and the trailers are split across them
+			for size := 1; size <= len(tt.chunked)+len(next); size++ {
+				r, err := parseHeaders(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+				if err != nil {
+					t.Fatalf("Parse() = %v", err)
+				}
+				body, n, complete, err := consumeBody(r, []byte(tt.chunked+next), size)
+				if tt.wantErr {
+					if _, ok := err.(*ParseError); !ok {
+						t.Fatalf("read by %d: ConsumeBody() = %v, want a ParseError", size, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("read by %d: ConsumeBody() = %v", size, err)
+				}
+				if !complete || n != len(tt.chunked) {
+					t.Fatalf("read by %d: consumed %d bytes, complete %v, want %d bytes complete",
+						size, n, complete, len(tt.chunked))
+				}
+				if string(body) != tt.body {
+					t.Fatalf("read by %d: body = %q, want %q", size, body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRawResponseConsumeBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  string
+		received string
+		body     string
+		complete bool
+	}{
+		{name: "Content-Length", headers: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n",
+			received: "helloHTTP/1.1", body: "hello", complete: true},
+		{name: "until EOF", headers: "HTTP/1.1 200 OK\r\n\r\n", received: "hello world", body: "hello world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for size := 1; size <= len(tt.received); size++ {
+				r, err := parseHeaders(t, tt.headers)
+				if err != nil {
+					t.Fatalf("Parse() = %v", err)
+				}
+				body, n, complete, err := consumeBody(r, []byte(tt.received), size)
+				if err != nil {
+					t.Fatalf("read by %d: ConsumeBody() = %v", size, err)
+				}
+				if string(body) != tt.body || n != len(tt.body) || complete != tt.complete {
+					t.Fatalf("read by %d: body %q, %d bytes, complete %v, want %q complete %v",
+						size, body, n, complete, tt.body, tt.complete)
+				}
+			}
+		})
+	}
+}
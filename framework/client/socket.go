@@ -0,0 +1,86 @@
+package client
+
+import (
+	rua "github.com/taoxinyi/rua/framework"
+	"net"
+	"syscall"
+	"time"
+)
+
+// defaultKeepAlive is the interval of the TCP keep-alive probes of net.Dialer by default
+const defaultKeepAlive = 15 * time.Second
+
+// setSocketOptions sets the socket options of the connection, only the buffer sizes apply to a Unix domain socket
+// the connection returned sets TCP_QUICKACK after every read if it's enabled
+func setSocketOptions(conn net.Conn, socket *rua.SocketConfig) (net.Conn, error) {
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		return conn, setBuffers(unixConn, socket)
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+	err := setBuffers(tcpConn, socket)
+	if err == nil && socket.Nagle {
+		err = tcpConn.SetNoDelay(false)
+	}
+	if err == nil && socket.Linger != 0 {
+		// a negative linger resets the connection on close, which is SetLinger(0)
+		seconds := 0
+		if socket.Linger > 0 {
+			seconds = int((socket.Linger + time.Second - 1) / time.Second)
+		}
+		err = tcpConn.SetLinger(seconds)
+	}
+	if err != nil || !socket.QuickAck {
+		return conn, err
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return conn, err
+	}
+	quickAck := &quickAckConn{TCPConn: tcpConn, rawConn: rawConn}
+	return quickAck, quickAck.setQuickAck()
+}
+
+// bufferSetter is either net.TCPConn or net.UnixConn
+type bufferSetter interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// setBuffers sets SO_RCVBUF and SO_SNDBUF if they are given
+func setBuffers(conn bufferSetter, socket *rua.SocketConfig) (err error) {
+	if socket.RecvBuffer > 0 {
+		err = conn.SetReadBuffer(socket.RecvBuffer)
+	}
+	if err == nil && socket.SendBuffer > 0 {
+		err = conn.SetWriteBuffer(socket.SendBuffer)
+	}
+	return err
+}
+
+// quickAckConn sets TCP_QUICKACK again after every read, since the kernel may fall back to the delayed ACKs
+type quickAckConn struct {
+	*net.TCPConn
+	rawConn syscall.RawConn
+}
+
+func (c *quickAckConn) Read(b []byte) (int, error) {
+	n, err := c.TCPConn.Read(b)
+	if err == nil {
+		err = c.setQuickAck()
+	}
+	return n, err
+}
+
+// setQuickAck sets TCP_QUICKACK on the socket
+func (c *quickAckConn) setQuickAck() (err error) {
+	controlErr := c.rawConn.Control(func(fd uintptr) {
+		err = setQuickAck(int(fd))
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package client
+
+import (
+	"os"
+	"syscall"
+)
+
+// setQuickAck sets TCP_QUICKACK on the socket fd
+func setQuickAck(fd int) error {
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_QUICKACK, 1))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package client
+
+import "errors"
+
+// setQuickAck is only supported on linux
+func setQuickAck(int) error {
+	return errors.New("TCP_QUICKACK is only supported on linux")
+}
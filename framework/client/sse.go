@@ -0,0 +1,369 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	rua "github.com/taoxinyi/rua/framework"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// sseAccept and sseCacheControl are added to the request unless they are given, so the server streams the events
+// without caching
+var (
+	sseAccept       = []byte("\r\nAccept: text/event-stream")
+	sseCacheControl = []byte("\r\nCache-Control: no-cache")
+)
+
+// sseHttpClient holds the responses of Server-Sent Events open, each call of a User returns the next event of its
+// stream, which is measured as the time to the first event or the gap since the previous one
+type sseHttpClient struct {
+	requestBytes    []byte
+	request         *http.Request
+	recvBufSize     int
+	timeout         time.Duration
+	captureResponse bool
+	// dialer dials the host:port of the URL or the Unix domain socket, useTLS is true for https
+	dialer    *dialer
+	useTLS    bool
+	tlsConfig *tls.Config
+}
+
+// NewSSEHttpClient returns a new sseHttpClient
+// the actual construction is implemented in Init
+func NewSSEHttpClient() *sseHttpClient {
+	return &sseHttpClient{}
+}
+
+func (c *sseHttpClient) Name() string {
+	return "sse"
+}
+
+func (c *sseHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if config.NoKeepAlive {
+		return errors.New("sse holds the responses open, it doesn't support no keep-alive")
+	}
+	if err = checkNoRecycling("sse", config); err != nil {
+		return err
+	}
+	u, err := url.Parse(config.RequestConfig.URL)
+	if err != nil {
+		return err
+	}
+	c.dialer, err = newDialer(config)
+	if err != nil {
+		return err
+	}
+	var headers []byte
+	if request.HttpRequest.Header.Get("Accept") == "" {
+		headers = append(headers, sseAccept...)
+	}
+	if request.HttpRequest.Header.Get("Cache-Control") == "" {
+		headers = append(headers, sseCacheControl...)
+	}
+	c.requestBytes = request.RawBytes
+	if headers != nil {
+		// after the request line
+		c.requestBytes = bytes.Replace(c.requestBytes, []byte("\r\n"), append(headers, "\r\n"...), 1)
+	}
+	if c.dialer.forward {
+		// the requests are sent to the http proxy with the absolute URL
+		c.requestBytes = forwardRequest(c.requestBytes, u, c.dialer.proxy)
+	}
+	c.request = request.HttpRequest
+	c.useTLS = u.Scheme != "http"
+	c.tlsConfig, err = config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = u.Hostname()
+	}
+	c.recvBufSize = config.RecvBufSize
+	c.timeout = config.Timeout
+	c.captureResponse = config.CaptureResponse
+	return nil
+}
+
+// dial creates a new TCP connection, or a TLS connection for https with the handshake measured
+func (c *sseHttpClient) dial() (conn net.Conn, dial rua.Dial, err error) {
+	if !c.useTLS {
+		return c.dialer.dial()
+	}
+	return c.dialer.dialTLS(c.tlsConfig)
+}
+
+// CreateUser in sseHttpClient creates a connection, the stream is requested by the first call
+func (c *sseHttpClient) CreateUser() (rua.User, error) {
+	conn, dial, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	return &sseUser{
+		conn:            conn,
+		reader:          bufio.NewReaderSize(conn, c.recvBufSize),
+		dial:            c.dial,
+		dials:           []rua.Dial{dial},
+		requestBytes:    c.requestBytes,
+		request:         c.request,
+		recvBufSize:     c.recvBufSize,
+		timeout:         c.timeout,
+		captureResponse: c.captureResponse,
+	}, nil
+}
+
+// sseUser reads the events of its stream one by one, and requests a new stream once it ends
+type sseUser struct {
+	// conn is nil if it's closed, a new one will be dialed for the next stream
+	conn   net.Conn
+	reader *bufio.Reader
+	dial   func() (net.Conn, rua.Dial, error)
+	// dials are the connections dialed but not reported in a Response yet
+	dials        []rua.Dial
+	requestBytes []byte
+	request      *http.Request
+	recvBufSize  int
+	timeout      time.Duration
+	// captureResponse is whether to copy the headers of the stream and the data of the events to the Response
+	captureResponse bool
+
+	// resp is the response of the stream, nil if there is none, body reads its body
+	resp *http.Response
+	body *bufio.Reader
+	// sent is the time the request of the stream was sent, last is the time of its previous event, and events is the
+	// number of its events so far
+	sent   time.Time
+	last   time.Time
+	events int
+	// line is the reusable buffer of a line longer than the buffer of body
+	line []byte
+}
+
+func (u *sseUser) DoStaticRequest(response *rua.Response) (err error) {
+	// the request is only counted if a new stream is requested in the call
+	response.NoRequest = true
+	response.RequestSize = len(u.requestBytes)
+	response.Reconnects = 0
+	response.Recycled = 0
+	response.Interim = 0
+	// the connection dialed in CreateUser is reported with the first response
+	response.Dials = append(response.Dials[:0], u.dials...)
+	u.dials = nil
+	response.Event = false
+	for {
+		if u.resp == nil {
+			if err = u.open(response); err != nil {
+				u.close()
+				return err
+			}
+			if !u.streaming() {
+				// not an event stream, e.g. an error, it's a response
+				return u.finish(response)
+			}
+		}
+		err = u.readEvent(response)
+		if err == nil {
+			return nil
+		}
+		if err != io.ErrUnexpectedEOF && !isConnectionClosed(err) {
+			u.close()
+			return err
+		}
+		// the stream ended, or the server closed the connection, a new one is requested
+		events := u.events
+		if err == io.EOF {
+			u.endStream()
+		} else {
+			u.close()
+		}
+		if events == 0 {
+			// no event at all, it's a response
+			response.Size = 0
+			return nil
+		}
+	}
+}
+
+// open requests a new stream and reads its headers, on a new connection if the previous one is closed
+func (u *sseUser) open(response *rua.Response) (err error) {
+	for retried := false; ; retried = true {
+		if u.conn == nil {
+			// the connection was closed after the previous stream
+			if err = u.connect(response); err != nil {
+				return err
+			}
+			response.Reconnects++
+		}
+		err = u.conn.SetDeadline(time.Now().Add(u.timeout))
+		if err != nil {
+			return err
+		}
+		if _, err = u.conn.Write(u.requestBytes); err == nil {
+			u.sent = time.Now()
+			response.NoRequest = false
+			u.resp, err = http.ReadResponse(u.reader, u.request)
+		}
+		if err != nil && isConnectionClosed(err) && !retried {
+			// the server closed the connection, e.g. after the previous stream, retry once on a new connection
+			u.close()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	response.StatusCode = u.resp.StatusCode
+	if u.captureResponse {
+		response.ResetCaptured()
+		for name, values := range u.resp.Header {
+			response.Headers[name] = append(response.Headers[name], values...)
+		}
+	}
+	u.body = bufio.NewReaderSize(u.resp.Body, u.recvBufSize)
+	u.events = 0
+	return nil
+}
+
+// streaming returns whether the response is an event stream
+func (u *sseUser) streaming() bool {
+	mediaType, _, _ := mime.ParseMediaType(u.resp.Header.Get("Content-Type"))
+	return u.resp.StatusCode == http.StatusOK && mediaType == "text/event-stream"
+}
+
+// finish reads the response which is not an event stream to the end
+func (u *sseUser) finish(response *rua.Response) error {
+	var n int64
+	var err error
+	if u.captureResponse {
+		var buffer bytes.Buffer
+		n, err = buffer.ReadFrom(u.body)
+		response.Body = append(response.Body, buffer.Bytes()...)
+	} else {
+		n, err = io.Copy(ioutil.Discard, u.body)
+	}
+	response.Size = int(n)
+	if err != nil {
+		u.close()
+		return err
+	}
+	u.endStream()
+	return nil
+}
+
+// readEvent reads the lines of the stream until the next event with data, the comments and the events without data
+// are skipped. It returns io.EOF if the stream ended
+func (u *sseUser) readEvent(response *rua.Response) error {
+	if u.captureResponse {
+		response.Body = response.Body[:0]
+	}
+	err := u.conn.SetReadDeadline(time.Now().Add(u.timeout))
+	if err != nil {
+		return err
+	}
+	size := 0
+	data := false
+	for {
+		line, n, err := u.readLine()
+		size += n
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			if data {
+				break
+			}
+			// the end of an event without data
+			continue
+		}
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i != -1 {
+			field, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+		}
+		if string(field) != "data" {
+			// a comment like a heartbeat, or a field other than data
+			continue
+		}
+		if u.captureResponse {
+			if data {
+				response.Body = append(response.Body, '\n')
+			}
+			response.Body = append(response.Body, value...)
+		}
+		data = true
+	}
+	now := time.Now()
+	response.Event = true
+	response.FirstEvent = u.events == 0
+	if response.FirstEvent {
+		response.EventLatency = now.Sub(u.sent)
+	} else {
+		response.EventLatency = now.Sub(u.last)
+	}
+	response.Size = size
+	u.last = now
+	u.events++
+	return nil
+}
+
+// readLine returns the next line of the stream without the line ending and the number of bytes read
+// the line is only valid until the next call
+func (u *sseUser) readLine() ([]byte, int, error) {
+	line, err := u.body.ReadSlice('\n')
+	n := len(line)
+	if err == bufio.ErrBufferFull {
+		u.line = append(u.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = u.body.ReadSlice('\n')
+			n += len(line)
+			u.line = append(u.line, line...)
+		}
+		line = u.line
+	}
+	if err != nil {
+		// the line not ended at the end of the stream is dropped
+		return nil, n, err
+	}
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return line, n, nil
+}
+
+// endStream closes the response of the stream, the connection is closed too unless it can be reused
+func (u *sseUser) endStream() {
+	if u.resp.Close {
+		u.close()
+		return
+	}
+	u.resp.Body.Close()
+	u.resp = nil
+	u.body = nil
+}
+
+// connect dials a new connection and reports it in the Response
+func (u *sseUser) connect(response *rua.Response) error {
+	conn, dial, err := u.dial()
+	if err != nil {
+		return err
+	}
+	u.conn = conn
+	u.reader = bufio.NewReaderSize(conn, u.recvBufSize)
+	response.Dials = append(response.Dials, dial)
+	return nil
+}
+
+// close closes the connection and the stream on it
+func (u *sseUser) close() {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+	u.resp = nil
+	u.body = nil
+}
@@ -0,0 +1,489 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wsGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the opcodes of the WebSocket frames
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsMaxMessage is the max size of a message received, so a broken server can't run out of the memory
+const wsMaxMessage = 64 << 20
+
+// wsUpgradeHeaders are the headers of the upgrade request set by ws, the ones given are ignored
+var wsUpgradeHeaders = map[string]bool{
+	"Host":                  true,
+	"Upgrade":               true,
+	"Connection":            true,
+	"Content-Length":        true,
+	"Sec-Websocket-Key":     true,
+	"Sec-Websocket-Version": true,
+}
+
+// wsHttpClient upgrades the connections to WebSocket and sends the message of the template on them, the latencies
+// are the round trips of the messages to their replies
+type wsHttpClient struct {
+	// dialer dials the host:port of the URL or the Unix domain socket, useTLS is true for wss and https
+	dialer    *dialer
+	useTLS    bool
+	tlsConfig *tls.Config
+	// upgrade is the upgrade request without Sec-WebSocket-Key and the empty line ending the headers
+	upgrade         []byte
+	message         wsTemplate
+	match           wsTemplate
+	recvBufSize     int
+	timeout         time.Duration
+	captureResponse bool
+}
+
+// NewWsHttpClient returns a new wsHttpClient
+// the actual construction is implemented in Init
+func NewWsHttpClient() *wsHttpClient {
+	return &wsHttpClient{}
+}
+
+func (c *wsHttpClient) Name() string {
+	return "ws"
+}
+
+func (c *wsHttpClient) Init(config *rua.LgConfig, request *rua.Request) (err error) {
+	if config.WebSocket.Message == "" {
+		return errors.New("ws requires the message to send")
+	}
+	if config.NoKeepAlive {
+		return errors.New("ws sends the messages on the upgraded connections, it doesn't support no keep-alive")
+	}
+	if err = checkNoRecycling("ws", config); err != nil {
+		return err
+	}
+	u, err := url.Parse(config.RequestConfig.URL)
+	if err != nil {
+		return err
+	}
+	c.dialer, err = newDialer(config)
+	if err != nil {
+		return err
+	}
+	// the upgrade request is sent through a tunnel instead of being forwarded by the http proxy
+	c.dialer.forward = false
+	c.useTLS = u.Scheme == "wss" || u.Scheme == "https"
+	c.tlsConfig, err = config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	if c.tlsConfig.ServerName == "" {
+		c.tlsConfig.ServerName = u.Hostname()
+	}
+	c.upgrade = wsUpgradeRequest(request.HttpRequest)
+	c.message = newWsTemplate(config.WebSocket.Message)
+	if config.WebSocket.Match != "" {
+		c.match = newWsTemplate(config.WebSocket.Match)
+	}
+	c.recvBufSize = config.RecvBufSize
+	c.timeout = config.Timeout
+	c.captureResponse = config.CaptureResponse
+	return nil
+}
+
+// wsUpgradeRequest returns the GET request to upgrade the connection to the URL of the request with its headers
+func wsUpgradeRequest(request *http.Request) []byte {
+	var b bytes.Buffer
+	host := request.Host
+	if h := request.Header.Get("Host"); h != "" {
+		host = h
+	}
+	fmt.Fprintf(&b, "GET %s HTTP/1.1\r\nHost: %s\r\n", request.URL.RequestURI(), host)
+	b.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	for name, values := range request.Header {
+		if wsUpgradeHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		for _, value := range values {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	return b.Bytes()
+}
+
+// dial creates a new connection like raw, and upgrades it to WebSocket with the handshake measured
+// the reader has the bytes received after the 101 response
+func (c *wsHttpClient) dial() (conn net.Conn, reader *bufio.Reader, dial rua.Dial, err error) {
+	if c.useTLS {
+		conn, dial, err = c.dialer.dialTLS(c.tlsConfig)
+	} else {
+		conn, dial, err = c.dialer.dial()
+	}
+	if err != nil {
+		return nil, nil, dial, err
+	}
+	reader = bufio.NewReaderSize(conn, c.recvBufSize)
+	start := time.Now()
+	err = conn.SetDeadline(start.Add(c.timeout))
+	if err == nil {
+		err = c.handshake(conn, reader)
+	}
+	dial.Upgrade = time.Since(start)
+	if err == nil {
+		// the deadline is set for each message
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, dial, err
+	}
+	return conn, reader, dial, nil
+}
+
+// handshake sends the upgrade request with a new key and checks the 101 response to it
+func (c *wsHttpClient) handshake(conn net.Conn, reader *bufio.Reader) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request := append(append([]byte{}, c.upgrade...), "Sec-WebSocket-Key: "+key+"\r\n\r\n"...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("websocket upgrade failed with %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return fmt.Errorf("websocket upgrade failed with Upgrade: %s", resp.Header.Get("Upgrade"))
+	}
+	accept := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(accept[:]) {
+		return errors.New("websocket upgrade failed with a wrong Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+// CreateUser in wsHttpClient creates a connection upgraded to WebSocket
+func (c *wsHttpClient) CreateUser() (rua.User, error) {
+	conn, reader, dial, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	return &wsUser{
+		conn:            conn,
+		reader:          reader,
+		dial:            c.dial,
+		dials:           []rua.Dial{dial},
+		message:         c.message,
+		match:           c.match,
+		timeout:         c.timeout,
+		captureResponse: c.captureResponse,
+		mask:            binary.LittleEndian.Uint64(seed[:]) | 1,
+	}, nil
+}
+
+// wsTemplate is a template split by rua.WebSocketSeq
+type wsTemplate [][]byte
+
+// newWsTemplate splits the template by rua.WebSocketSeq
+func newWsTemplate(template string) wsTemplate {
+	var t wsTemplate
+	for _, part := range strings.Split(template, rua.WebSocketSeq) {
+		t = append(t, []byte(part))
+	}
+	return t
+}
+
+// expand appends the template with seq in place of rua.WebSocketSeq to dst
+func (t wsTemplate) expand(dst []byte, seq []byte) []byte {
+	for i, part := range t {
+		if i > 0 {
+			dst = append(dst, seq...)
+		}
+		dst = append(dst, part...)
+	}
+	return dst
+}
+
+// wsUser sends the messages one after another on its connection, each waits for its reply
+type wsUser struct {
+	// conn is nil if it's closed, a new one will be dialed for the next message
+	conn   net.Conn
+	reader *bufio.Reader
+	dial   func() (net.Conn, *bufio.Reader, rua.Dial, error)
+	// dials are the connections dialed but not reported in a Response yet
+	dials []rua.Dial
+	// seq is the sequence number of the last message sent on the connection
+	seq     uint64
+	message wsTemplate
+	// match is nil if any message is the reply
+	match           wsTemplate
+	timeout         time.Duration
+	captureResponse bool
+	// mask is the xorshift state of the masking keys of the frames sent
+	mask uint64
+	// the reusable buffers of the sequence number, the message and its frame, the expected substring of the reply,
+	// the message received and the control frame received
+	seqBuf   []byte
+	payload  []byte
+	frame    []byte
+	expected []byte
+	received []byte
+	control  []byte
+}
+
+func (u *wsUser) DoStaticRequest(response *rua.Response) (err error) {
+	start := time.Now()
+	// the message is counted once it's sent
+	response.NoRequest = true
+	response.Setup = 0
+	response.Reconnects = 0
+	response.Recycled = 0
+	response.Interim = 0
+	// the connection dialed in CreateUser is reported with the first response
+	response.Dials = append(response.Dials[:0], u.dials...)
+	u.dials = nil
+	if u.conn == nil {
+		// the connection was closed after the previous message
+		err = u.reconnect(response)
+		if err != nil {
+			return err
+		}
+	}
+	err = u.roundTrip(response, start)
+	if err == errClosedBeforeResponse {
+		// the server closed the connection, retry once on a new connection
+		err = u.reconnect(response)
+		if err == nil {
+			err = u.roundTrip(response, start)
+		}
+	}
+	if err != nil {
+		u.close()
+		return err
+	}
+	// the replies are on the upgraded connection
+	response.StatusCode = http.StatusSwitchingProtocols
+	return nil
+}
+
+// reconnect closes the current connection if any, and dials a new one to replace it. If the dial fails, the User goes
+// on and it's dialed again in the next call
+func (u *wsUser) reconnect(response *rua.Response) error {
+	u.close()
+	conn, reader, dial, err := u.dial()
+	if err != nil {
+		return &rua.ConnectionError{Err: err}
+	}
+	u.conn, u.reader = conn, reader
+	u.seq = 0
+	response.Dials = append(response.Dials, dial)
+	response.Reconnects++
+	return nil
+}
+
+// close closes the connection
+func (u *wsUser) close() {
+	if u.conn != nil {
+		u.conn.Close()
+		u.conn = nil
+	}
+}
+
+// roundTrip sends the next message and reads the messages received until its reply, the time since start before the
+// message is sent, e.g. to reconnect, is excluded from the latency
+func (u *wsUser) roundTrip(response *rua.Response, start time.Time) error {
+	u.seq++
+	u.seqBuf = strconv.AppendUint(u.seqBuf[:0], u.seq, 10)
+	u.payload = u.message.expand(u.payload[:0], u.seqBuf)
+	err := u.conn.SetDeadline(time.Now().Add(u.timeout))
+	if err != nil {
+		return err
+	}
+	response.Setup = time.Since(start)
+	if err = u.write(wsText, u.payload); err != nil {
+		if isConnectionClosed(err) {
+			return errClosedBeforeResponse
+		}
+		return err
+	}
+	response.NoRequest = false
+	response.RequestSize = len(u.frame)
+	var expected []byte
+	if u.match != nil {
+		u.expected = u.match.expand(u.expected[:0], u.seqBuf)
+		expected = u.expected
+	}
+	size := 0
+	for received := false; ; received = true {
+		n, err := u.readMessage()
+		size += n
+		if err != nil {
+			if !received && isConnectionClosed(err) {
+				return errClosedBeforeResponse
+			}
+			return err
+		}
+		if expected == nil || bytes.Contains(u.received, expected) {
+			break
+		}
+	}
+	response.Size = size
+	if u.captureResponse {
+		response.ResetCaptured()
+		response.Body = append(response.Body, u.received...)
+	}
+	return nil
+}
+
+// write sends the payload in a frame of the opcode, masked with a new key as required for the clients
+func (u *wsUser) write(opcode byte, payload []byte) error {
+	frame := append(u.frame[:0], 0x80|opcode)
+	n := len(payload)
+	switch {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	u.mask ^= u.mask << 13
+	u.mask ^= u.mask >> 7
+	u.mask ^= u.mask << 17
+	key := len(frame)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(u.mask))
+	start := len(frame)
+	frame = append(frame, payload...)
+	for i := start; i < len(frame); i++ {
+		frame[i] ^= frame[key+(i-start)%4]
+	}
+	u.frame = frame
+	_, err := u.conn.Write(frame)
+	return err
+}
+
+// readMessage reads the frames of the next data message into received, and answers the control frames in between
+// it returns the number of bytes read
+func (u *wsUser) readMessage() (size int, err error) {
+	u.received = u.received[:0]
+	for {
+		fin, opcode, n, err := u.readFrame()
+		size += n
+		if err != nil {
+			return size, err
+		}
+		switch opcode {
+		case wsPing:
+			if err = u.write(wsPong, u.control); err != nil {
+				return size, err
+			}
+		case wsPong:
+		case wsClose:
+			code := 0
+			if len(u.control) >= 2 {
+				code = int(binary.BigEndian.Uint16(u.control))
+			}
+			// echo the status code and close, the message is lost like the connection closed
+			u.write(wsClose, u.control[:min(len(u.control), 2)])
+			return size, fmt.Errorf("websocket closed by the server with %d: %w", code, io.EOF)
+		default:
+			if fin {
+				return size, nil
+			}
+		}
+	}
+}
+
+// readFrame reads a frame, the payload of a data frame is appended to received, the one of a control frame is in
+// control. It returns the number of bytes read
+func (u *wsUser) readFrame() (fin bool, opcode byte, size int, err error) {
+	var header [8]byte
+	if _, err = io.ReadFull(u.reader, header[:2]); err != nil {
+		return false, 0, 0, err
+	}
+	size = 2
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 != 0 {
+		return fin, opcode, size, errors.New("websocket frame masked by the server")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(u.reader, header[:2]); err != nil {
+			return fin, opcode, size, err
+		}
+		size += 2
+		length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err = io.ReadFull(u.reader, header[:]); err != nil {
+			return fin, opcode, size, err
+		}
+		size += 8
+		length = binary.BigEndian.Uint64(header[:])
+	}
+	var dst []byte
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		if length > uint64(wsMaxMessage-len(u.received)) {
+			return fin, opcode, size, fmt.Errorf("websocket message larger than %d bytes", wsMaxMessage)
+		}
+		dst = u.received
+	case wsClose, wsPing, wsPong:
+		if length > 125 || !fin {
+			return fin, opcode, size, errors.New("websocket control frame too large or fragmented")
+		}
+		dst = u.control[:0]
+	default:
+		return fin, opcode, size, fmt.Errorf("websocket frame with unknown opcode %d", opcode)
+	}
+	// grow the reusable buffer in place, it's reallocated only if the payload doesn't fit
+	start := len(dst)
+	end := start + int(length)
+	if end > cap(dst) {
+		grown := make([]byte, start, max(end, 2*cap(dst)))
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:end]
+	n, err := io.ReadFull(u.reader, dst[start:])
+	size += n
+	if opcode&0x8 == 0 {
+		u.received = dst
+	} else {
+		u.control = dst
+	}
+	return fin, opcode, size, err
+}
@@ -0,0 +1,13 @@
+package framework
+
+// GRPCConfig is the configuration of the unary RPCs, only for `grpc`
+type GRPCConfig struct {
+	// Method is the full name of the method like package.Service/Method
+	Method string
+	// DescriptorSet is the path of the FileDescriptorSet with the method and its messages, e.g. written by
+	// protoc --include_imports --descriptor_set_out
+	DescriptorSet string
+	// Data is the request message in JSON, which requires DescriptorSet. The request body is the message in protobuf
+	// if it's empty
+	Data string
+}
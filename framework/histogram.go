@@ -0,0 +1,123 @@
+package framework
+
+import (
+	"math"
+	"math/bits"
+)
+
+// histogramSubBuckets is the number of the values less than it, which are recorded exactly
+// each power of 2 above them has half as many buckets, so the relative error is within 1/512
+const histogramSubBuckets = 1024
+const histogramSubBucketBits = 10
+
+// Histogram is a compact log-linear histogram of non negative values, e.g. latencies in microseconds
+// unlike Stats.Latencies, its size grows with the max value instead of the timeout, so it's used for the metrics
+// other than the response latency, e.g. the TLS handshakes
+type Histogram struct {
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+	// counts is the number of values of each bucket, see histogramIndex
+	counts []int64
+}
+
+// histogramIndex returns the index of the bucket of the value
+func histogramIndex(v int64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// v>>shift is within [histogramSubBuckets/2, histogramSubBuckets)
+	shift := bits.Len64(uint64(v)) - histogramSubBucketBits
+	return histogramSubBuckets + (shift-1)*histogramSubBuckets/2 + int(v>>uint(shift)) - histogramSubBuckets/2
+}
+
+// histogramValue returns the lowest value of the bucket of the index
+func histogramValue(i int) int64 {
+	if i < histogramSubBuckets {
+		return int64(i)
+	}
+	i -= histogramSubBuckets
+	shift := i/(histogramSubBuckets/2) + 1
+	return int64(i%(histogramSubBuckets/2)+histogramSubBuckets/2) << uint(shift)
+}
+
+// Record adds a value to the histogram, negative values are recorded as 0
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	i := histogramIndex(v)
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	if h.Count == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Count++
+	h.Sum += v
+}
+
+// Merge adds all the values of the other histogram
+func (h *Histogram) Merge(other *Histogram) {
+	if other.Count == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// Mean returns the mean of the values, or 0 if it's empty
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Percentile returns the value of the percentile within [0, 100], or 0 if it's empty
+func (h *Histogram) Percentile(percentile float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(h.Count)))
+	if target < 1 {
+		target = 1
+	}
+	var count int64
+	for i, c := range h.counts {
+		count += c
+		if count >= target {
+			// the min and the max are exact, the others are the lowest values of their buckets
+			v := histogramValue(i)
+			if count == h.Count || v > h.Max {
+				return h.Max
+			}
+			if v < h.Min {
+				return h.Min
+			}
+			return v
+		}
+	}
+	return h.Max
+}
@@ -2,10 +2,9 @@ package framework
 
 import (
 	"net/http"
+	"time"
 )
 
-
-
 // Request contains a net.http.Request as well the raw bytes
 type Request struct {
 	HttpRequest *http.Request
@@ -14,7 +13,107 @@ type Request struct {
 type Response struct {
 	// StatusCode is the Http Status code
 	// Size is the total size of the response
-	StatusCode    int
-	Size          int
+	StatusCode int
+	Size       int
+	// GRPCStatus is the name of the gRPC status code of the response like UNAVAILABLE, it's counted instead of
+	// StatusCode if it's not empty. It should be set by the User for every call if it's a gRPC client
+	GRPCStatus string
+	// Event is whether the call received an event of a streaming response instead of a response, e.g. of SSE, it's
+	// recorded in the event stats instead of the latencies. FirstEvent is whether it's the first event of the stream,
+	// EventLatency is the time since the request then, or since the previous event of the stream otherwise
+	// They should be set by the User for every call if it's a streaming client
+	Event        bool
+	FirstEvent   bool
+	EventLatency time.Duration
+	// Pipelined is true if the request of the response was sent in a previous DoStaticRequest together with others
+	// so that the latency is measured from that call instead of this one. It should be set by the User for every call
+	Pipelined bool
+	// Setup is the time spent in the call before the request was sent, e.g. to dial a new connection, it's excluded
+	// from the latency. It should be set by the User for every call if it's not included, e.g. by a WebSocket client
+	Setup time.Duration
+	// RequestSize is the size of the request sent in the call, it's the size of Request.RawBytes if 0. NoRequest is
+	// true if no request was sent in the call, e.g. it only received the next event of a stream
+	// They should be set by the User for every call if its requests are not Request.RawBytes, e.g. WebSocket messages
+	RequestSize int
+	NoRequest   bool
+	// Reconnects is the number of new connections the User dialed during the call to replace the closed ones
+	// It should be set by the User for every call
+	Reconnects int
+	// Recycled is the number of connections the User replaced during the call since they reached
+	// LgConfig.MaxRequestsPerConn or LgConfig.MaxConnAge. It should be set by the User for every call
+	Recycled int
+	// Interim is the number of 1xx interim responses, e.g. 100 Continue, received before the final response
+	// It should be set by the User for every call
+	Interim int
+	// Dials are the new connections the User dialed during the call, or before the first call in CreateUser
+	// It should be set by the User for every call, it's optional if the User doesn't know how it dials
+	Dials []Dial
+
+	// Headers and Body are only filled if LgConfig.CaptureResponse is true, they should be reused between responses
+	Headers http.Header
+	Body    []byte
+}
+
+// ResetCaptured clears the Headers and the Body so they can be filled again for a new response
+func (r *Response) ResetCaptured() {
+	for name := range r.Headers {
+		delete(r.Headers, name)
+	}
+	r.Body = r.Body[:0]
+}
+
+// ProtocolError is returned by User.DoStaticRequest if only the request failed, e.g. its HTTP/2 stream is reset, so
+// that the User goes on with the next request. They are counted by Kind in Stats.ProtocolErrors
+type ProtocolError struct {
+	// Kind is like RST_STREAM REFUSED_STREAM, it should have few distinct values
+	Kind string
+	Err  error
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Kind + ": " + e.Err.Error()
+}
+
+// ConnectionError is returned by User.DoStaticRequest if the connection failed but the User can go on with a new one,
+// e.g. the dial of the new connection failed, so that it's dialed again in the next call. It's counted as a connection
+// error, or a timeout error, without ending the User
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return e.Err.Error()
+}
+
+// Dial is how a new connection was established
+type Dial struct {
+	// RemoteIP is the IP of the host connected to, empty if the connections are not spread across the IPs
+	RemoteIP string
+	// LocalIP is the local IP the connection is bound to, empty if LgConfig.LocalIPs is empty
+	LocalIP string
+	// Connect is the time to establish the TCP connection, or the Unix domain socket connection, to the target or the
+	// proxy. It's 0 for QUIC, whose connection is established by the handshake
+	Connect time.Duration
+	// Proxy is the time to connect to the proxy and to set up the tunnel through it, 0 if there is no proxy
+	Proxy time.Duration
+	// TLS is whether it's a TLS connection
+	// Handshake is the time of the TLS handshake, Resumed is whether the TLS session was resumed from a previous one
+	// EarlyData is whether the server accepted the 0-RTT data of the resumed QUIC handshake, which doesn't carry any
+	// request since the requests are sent once the handshake completes
+	TLS       bool
+	Handshake time.Duration
+	Resumed   bool
+	EarlyData bool
+	// Upgrade is the time of the WebSocket upgrade handshake, from the upgrade request to the 101 response, 0 if none
+	Upgrade time.Duration
+}
 
+// Duration returns the time to establish the connection, including the proxy and the TLS handshake
+func (d *Dial) Duration() time.Duration {
+	duration := d.Connect
+	if d.Proxy > 0 {
+		// it includes connecting to the proxy
+		duration = d.Proxy
+	}
+	return duration + d.Handshake
 }
@@ -0,0 +1,47 @@
+package framework
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordFailedResponseOnce(t *testing.T) {
+	tests := []struct {
+		name             string
+		validation       ValidationConfig
+		response         Response
+		statusErrors     int64
+		validationErrors int64
+	}{
+		{name: "status error", response: Response{StatusCode: 500}, statusErrors: 1},
+		{name: "unexpected status error", validation: ValidationConfig{StatusCodes: []int{200}},
+			response: Response{StatusCode: 500}, statusErrors: 1},
+		{name: "unexpected status", validation: ValidationConfig{StatusCodes: []int{204}},
+			response: Response{StatusCode: 200}, validationErrors: 1},
+		{name: "expected status error", validation: ValidationConfig{StatusCodes: []int{503}},
+			response: Response{StatusCode: 503}, statusErrors: 1},
+		{name: "body of status error", validation: ValidationConfig{BodyContains: []string{"ok"}},
+			response: Response{StatusCode: 500, Body: []byte("error")}, statusErrors: 1},
+		{name: "body", validation: ValidationConfig{BodyContains: []string{"ok"}},
+			response: Response{StatusCode: 200, Body: []byte("error")}, validationErrors: 1},
+		{name: "gRPC status error", validation: ValidationConfig{StatusCodes: []int{200}},
+			response: Response{StatusCode: 200, GRPCStatus: "UNAVAILABLE"}, statusErrors: 1},
+		{name: "passed", validation: ValidationConfig{StatusCodes: []int{200}, BodyContains: []string{"ok"}},
+			response: Response{StatusCode: 200, Body: []byte("ok")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &LgConfig{Duration: time.Second, Timeout: time.Second, Validation: tt.validation}
+			stats := newStats(config)
+			recorder := &Recorder{l: &loadGenerator{config: config, request: &Request{}}, stats: stats}
+			recorder.record(&tt.response, 0, int64(time.Millisecond))
+			if stats.StatusErrors != tt.statusErrors || stats.ValidationErrors != tt.validationErrors {
+				t.Errorf("status errors %d, validation errors %d, want %d and %d",
+					stats.StatusErrors, stats.ValidationErrors, tt.statusErrors, tt.validationErrors)
+			}
+			if want := tt.statusErrors + tt.validationErrors; stats.Errors() != want {
+				t.Errorf("Errors() = %d, want %d", stats.Errors(), want)
+			}
+		})
+	}
+}
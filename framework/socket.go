@@ -0,0 +1,21 @@
+package framework
+
+import "time"
+
+// SocketConfig is the socket options of the TCP connections, which are the same for all the HttpClients
+// the zero value keeps the defaults of net.Dial
+type SocketConfig struct {
+	// Nagle is whether to enable Nagle's algorithm, i.e. TCP_NODELAY off. It's disabled by default
+	Nagle bool
+	// SendBuffer and RecvBuffer are SO_SNDBUF and SO_RCVBUF in bytes, the defaults of the OS if 0
+	SendBuffer int
+	RecvBuffer int
+	// KeepAlive is the interval of the TCP keep-alive probes, 15s if 0, negative to disable them
+	KeepAlive time.Duration
+	// Linger is SO_LINGER, the time to send the data left when the connection is closed, the default of the OS
+	// if 0, negative to reset the connection on close instead
+	Linger time.Duration
+	// QuickAck is whether to set TCP_QUICKACK to disable the delayed ACKs, it's set again after every read since
+	// it's not permanent. Only supported on linux
+	QuickAck bool
+}
@@ -14,6 +14,10 @@ type Stats struct {
 	// BytesRecv is the total number of bytes received
 	BytesSent int64
 	BytesRecv int64
+	// Events is the number of the events of the streaming responses, which are not in ResponsesRecv
+	// EventBytes is the number of bytes of the events, which are not in BytesRecv
+	Events     int64
+	EventBytes int64
 
 	// Latencies is all latency in frequency map, key is microseconds (us, 1/1000ms)
 	// Latencies[1234]=3 => 3 requests has latency 1.234 ms
@@ -30,34 +34,133 @@ type Stats struct {
 	StatusErrors     int64 // error responses status  > 399
 	TimeoutErrors    int64 // timeouts
 	ConnectionErrors int64 // connections
+	ValidationErrors int64 // responses failing LgConfig.Validation
+	// ProtocolErrors is the number of requests failed by each kind of protocol error, e.g. the HTTP/2 streams reset
+	ProtocolErrors map[string]int64
+	// GRPCStatuses is the number of responses of each gRPC status code like OK, the ones other than OK are also
+	// counted in StatusErrors
+	GRPCStatuses map[string]int64
+
+	// Reconnects is the number of new connections dialed to replace the ones closed by the server
+	Reconnects int64
+	// Recycled is the number of connections replaced since they reached LgConfig.MaxRequestsPerConn or
+	// LgConfig.MaxConnAge
+	Recycled int64
+	// InterimResponses is the number of 1xx interim responses received before the final ones, which are not counted
+	// in ResponsesRecv
+	InterimResponses int64
+
+	// RemoteIPConnections is the number of connections to each IP of the host, if they are spread across the IPs
+	RemoteIPConnections map[string]int64
+	// LocalIPConnections is the number of connections bound to each local IP if LgConfig.LocalIPs is not empty
+	LocalIPConnections map[string]int64
+	// Connects are the durations to establish the connections, in microseconds
+	Connects Histogram
+	// ProxyConnects are the durations to connect through the proxy, in microseconds
+	ProxyConnects Histogram
+	// FullHandshakes and ResumedHandshakes are the durations of the TLS handshakes, in microseconds
+	// ZeroRTTHandshakes are the resumed QUIC handshakes with the 0-RTT data accepted, which are not in ResumedHandshakes
+	FullHandshakes    Histogram
+	ResumedHandshakes Histogram
+	ZeroRTTHandshakes Histogram
+	// Upgrades are the durations of the WebSocket upgrade handshakes, in microseconds
+	Upgrades Histogram
+	// FirstEvents are the times from the requests to the first events of the streaming responses, EventGaps are the
+	// times between the events of a stream, in microseconds
+	FirstEvents Histogram
+	EventGaps   Histogram
 
 	limit int64 // upper bound of latency
 
+	// Timeline is the stats of each TimelineInterval since the test started, e.g. for throughput over time
+	// the responses received after the test duration (within the timeout) are in the last few Intervals
+	Timeline         []Interval
+	TimelineInterval time.Duration
+
+	intervalUs int64 // TimelineInterval in microseconds
+}
+
+// Interval is the stats of the responses and the events received within one TimelineInterval
+type Interval struct {
+	Responses  int64
+	BytesRecv  int64
+	LatencySum int64 // sum of the latencies, in microseconds
+	MaxLatency int64 // max latency, in microseconds
+	// Events are the events of the streaming responses, their latencies are the times to the first events or the gaps
+	// between the events
+	Events          int64
+	EventLatencySum int64
+	MaxEventLatency int64
+}
+
+// LatencyMean returns the mean latency of the responses in the Interval, in microseconds
+func (i *Interval) LatencyMean() float64 {
+	if i.Responses == 0 {
+		return 0
+	}
+	return float64(i.LatencySum) / float64(i.Responses)
+}
+
+// EventLatencyMean returns the mean latency of the events in the Interval, in microseconds
+func (i *Interval) EventLatencyMean() float64 {
+	if i.Events == 0 {
+		return 0
+	}
+	return float64(i.EventLatencySum) / float64(i.Events)
 }
 
-func newStats(timeout time.Duration) *Stats {
-	limit := timeout.Microseconds() + 1
+func newStats(config *LgConfig) *Stats {
+	limit := config.Timeout.Microseconds() + 1
+	interval := timelineInterval(config.Duration)
+	// the responses can be received until the timeout after the duration
+	intervals := (config.Duration+config.Timeout)/interval + 1
 	return &Stats{
-		limit:      limit,
-		Latencies:  make([]int64, limit, limit),
-		MinLatency: limit - 1,
+		limit:               limit,
+		Latencies:           make([]int64, limit, limit),
+		MinLatency:          limit - 1,
+		Timeline:            make([]Interval, intervals, intervals),
+		ProtocolErrors:      make(map[string]int64),
+		GRPCStatuses:        make(map[string]int64),
+		RemoteIPConnections: make(map[string]int64),
+		LocalIPConnections:  make(map[string]int64),
+		TimelineInterval:    interval,
+		intervalUs:          interval.Microseconds(),
+	}
+}
+
+// timelineInterval returns the interval of the Timeline so that there are around 100 Intervals for the duration
+// but each of them is at least 100ms
+func timelineInterval(duration time.Duration) time.Duration {
+	interval := (duration / 100).Truncate(time.Millisecond)
+	if interval < 100*time.Millisecond {
+		return 100 * time.Millisecond
 	}
+	return interval
 }
 func (s *Stats) recordRequest(requestSize int64) {
 	s.RequestsSent++
 	s.BytesSent += requestSize
 }
-func (s *Stats) recordResponse(latency int64, response *Response) {
+
+// recordResponse records the response with its latency, elapsed is the time since the test started
+// both latency and elapsed are in microseconds. It returns whether the response is counted as a status error
+func (s *Stats) recordResponse(latency int64, elapsed int64, response *Response) (statusError bool) {
 	if latency >= s.limit {
-		return
+		return false
 	}
 
 	// update received
 	s.ResponsesRecv++
 	s.BytesRecv += int64(response.Size)
 
-	// verify response code
-	if response.StatusCode > 399 {
+	// verify response code, or the gRPC status code
+	if response.GRPCStatus != "" {
+		s.GRPCStatuses[response.GRPCStatus]++
+		statusError = response.GRPCStatus != "OK"
+	} else {
+		statusError = response.StatusCode > 399
+	}
+	if statusError {
 		s.StatusErrors++
 	}
 
@@ -69,6 +172,74 @@ func (s *Stats) recordResponse(latency int64, response *Response) {
 	if latency > s.MaxLatency {
 		s.MaxLatency = latency
 	}
+
+	// update timeline
+	interval := s.interval(elapsed)
+	interval.Responses++
+	interval.BytesRecv += int64(response.Size)
+	interval.LatencySum += latency
+	if latency > interval.MaxLatency {
+		interval.MaxLatency = latency
+	}
+	return statusError
+}
+
+// recordEvent records the event of a streaming response, elapsed is the time since the test started in microseconds
+func (s *Stats) recordEvent(response *Response, elapsed int64) {
+	s.Events++
+	s.EventBytes += int64(response.Size)
+	latency := response.EventLatency.Microseconds()
+	if response.FirstEvent {
+		s.FirstEvents.Record(latency)
+	} else {
+		s.EventGaps.Record(latency)
+	}
+
+	// update timeline
+	interval := s.interval(elapsed)
+	interval.Events++
+	interval.BytesRecv += int64(response.Size)
+	interval.EventLatencySum += latency
+	if latency > interval.MaxEventLatency {
+		interval.MaxEventLatency = latency
+	}
+}
+
+// interval returns the Interval of the Timeline at elapsed, the time since the test started in microseconds
+func (s *Stats) interval(elapsed int64) *Interval {
+	i := int(elapsed / s.intervalUs)
+	if i >= len(s.Timeline) {
+		i = len(s.Timeline) - 1
+	}
+	return &s.Timeline[i]
+}
+
+// recordDial records how a new connection was established
+func (s *Stats) recordDial(dial *Dial) {
+	if dial.Connect > 0 {
+		s.Connects.Record(dial.Connect.Microseconds())
+	}
+	if dial.RemoteIP != "" {
+		s.RemoteIPConnections[dial.RemoteIP]++
+	}
+	if dial.LocalIP != "" {
+		s.LocalIPConnections[dial.LocalIP]++
+	}
+	if dial.Proxy > 0 {
+		s.ProxyConnects.Record(dial.Proxy.Microseconds())
+	}
+	if dial.TLS {
+		if dial.EarlyData {
+			s.ZeroRTTHandshakes.Record(dial.Handshake.Microseconds())
+		} else if dial.Resumed {
+			s.ResumedHandshakes.Record(dial.Handshake.Microseconds())
+		} else {
+			s.FullHandshakes.Record(dial.Handshake.Microseconds())
+		}
+	}
+	if dial.Upgrade > 0 {
+		s.Upgrades.Record(dial.Upgrade.Microseconds())
+	}
 }
 
 func (s *Stats) mergeStats(other *Stats) {
@@ -77,10 +248,36 @@ func (s *Stats) mergeStats(other *Stats) {
 
 	s.BytesSent += other.BytesSent
 	s.BytesRecv += other.BytesRecv
+	s.Events += other.Events
+	s.EventBytes += other.EventBytes
 
 	s.StatusErrors += other.StatusErrors
 	s.TimeoutErrors += other.TimeoutErrors
 	s.ConnectionErrors += other.ConnectionErrors
+	s.ValidationErrors += other.ValidationErrors
+	for kind, count := range other.ProtocolErrors {
+		s.ProtocolErrors[kind] += count
+	}
+	for status, count := range other.GRPCStatuses {
+		s.GRPCStatuses[status] += count
+	}
+	s.Reconnects += other.Reconnects
+	s.Recycled += other.Recycled
+	s.InterimResponses += other.InterimResponses
+	for ip, connections := range other.RemoteIPConnections {
+		s.RemoteIPConnections[ip] += connections
+	}
+	for ip, connections := range other.LocalIPConnections {
+		s.LocalIPConnections[ip] += connections
+	}
+	s.Connects.Merge(&other.Connects)
+	s.ProxyConnects.Merge(&other.ProxyConnects)
+	s.FullHandshakes.Merge(&other.FullHandshakes)
+	s.ResumedHandshakes.Merge(&other.ResumedHandshakes)
+	s.ZeroRTTHandshakes.Merge(&other.ZeroRTTHandshakes)
+	s.Upgrades.Merge(&other.Upgrades)
+	s.FirstEvents.Merge(&other.FirstEvents)
+	s.EventGaps.Merge(&other.EventGaps)
 
 	s.MinLatency = min(s.MinLatency, other.MinLatency)
 	s.MaxLatency = max(s.MaxLatency, other.MaxLatency)
@@ -88,9 +285,28 @@ func (s *Stats) mergeStats(other *Stats) {
 	for i := other.MinLatency; i <= other.MaxLatency; i++ {
 		s.Latencies[i] += other.Latencies[i]
 	}
+	for i := range other.Timeline {
+		interval := &s.Timeline[i]
+		interval.Responses += other.Timeline[i].Responses
+		interval.BytesRecv += other.Timeline[i].BytesRecv
+		interval.LatencySum += other.Timeline[i].LatencySum
+		interval.MaxLatency = max(interval.MaxLatency, other.Timeline[i].MaxLatency)
+		interval.Events += other.Timeline[i].Events
+		interval.EventLatencySum += other.Timeline[i].EventLatencySum
+		interval.MaxEventLatency = max(interval.MaxEventLatency, other.Timeline[i].MaxEventLatency)
+	}
+}
+
+// Errors returns the total number of errors of all kinds
+func (s *Stats) Errors() int64 {
+	errors := s.StatusErrors + s.TimeoutErrors + s.ConnectionErrors + s.ValidationErrors
+	for _, count := range s.ProtocolErrors {
+		errors += count
+	}
+	return errors
 }
 func (s *Stats) LatencyMean() float64 {
-	if s.RequestsSent == 0 {
+	if s.ResponsesRecv == 0 {
 		return 0
 	}
 	// already calculated
@@ -121,6 +337,9 @@ func (s *Stats) LatencyStdev() float64 {
 	return math.Sqrt(sum / float64(s.ResponsesRecv-1))
 }
 func (s *Stats) LatencyPercentageWithinStdev(n int) float64 {
+	if s.ResponsesRecv == 0 {
+		return 0
+	}
 	mean := s.LatencyMean()
 	stdev := s.LatencyStdev()
 	upper := int64(math.Ceil(mean + (float64(n) * stdev)))
@@ -134,6 +353,8 @@ func (s *Stats) LatencyPercentageWithinStdev(n int) float64 {
 	}
 	return 100.0 * float64(sum) / float64(s.ResponsesRecv)
 }
+
+// LatencyPercentile returns the latency at the percent of the responses received, the failed requests have no latency
 func (s *Stats) LatencyPercentile(percent float64) int64 {
 	if percent < 0.0 || percent > 100 {
 		return 0
@@ -141,7 +362,7 @@ func (s *Stats) LatencyPercentile(percent float64) int64 {
 	if percent == 100.0 {
 		return s.MaxLatency
 	}
-	rank := int64(math.Round(percent/100.0*float64(s.RequestsSent) + 0.5))
+	rank := int64(math.Round(percent/100.0*float64(s.ResponsesRecv) + 0.5))
 	var total int64 = 0
 	for i := s.MinLatency; i <= s.MaxLatency; i++ {
 		total += s.Latencies[i]
@@ -0,0 +1,69 @@
+package framework
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig is the TLS configuration of https, which is the same for all the HttpClients
+type TLSConfig struct {
+	// InsecureSkipVerify is whether to skip verifying the server certificate chain and host name
+	InsecureSkipVerify bool
+	// CAFile is the path of the PEM encoded CA bundle to verify the server certificate, the system one if empty
+	CAFile string
+	// CertFile and KeyFile are the paths of the PEM encoded client certificate and its key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name of the URL for SNI and verifying the server certificate
+	ServerName string
+	// MinVersion and MaxVersion are the TLS versions like tls.VersionTLS12, the defaults of crypto/tls if 0
+	MinVersion uint16
+	MaxVersion uint16
+	// CipherSuites are the TLS 1.0-1.2 cipher suites, the defaults of crypto/tls if empty
+	CipherSuites []uint16
+	// NextProtos are the ALPN protocols, e.g. http/1.1
+	NextProtos []string
+	// SessionResumption is whether to resume the TLS sessions of the previous connections with session tickets
+	SessionResumption bool
+	// HandshakeEvery is the number of requests sent on a connection before it's replaced with a new one, so that
+	// the TLS handshakes are measured. 0 to keep the connections alive. Only supported by raw and h3
+	HandshakeEvery int
+}
+
+// ClientConfig builds the tls.Config, the files are loaded every time it's called
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ServerName:         c.ServerName,
+		MinVersion:         c.MinVersion,
+		MaxVersion:         c.MaxVersion,
+		CipherSuites:       c.CipherSuites,
+		NextProtos:         c.NextProtos,
+	}
+	if c.SessionResumption {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM encoded certificate in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, fmt.Errorf("both the client certificate and the key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,143 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValidationConfig contains the assertions on each response
+// a response failing any of them is counted as Stats.ValidationErrors
+type ValidationConfig struct {
+	// StatusCodes is the set of expected status codes, any status code is expected if empty
+	StatusCodes []int
+	// Headers are the assertions on the response headers
+	Headers []HeaderRule
+	// BodyContains are the substrings the body must contain
+	BodyContains []string
+	// BodyMatches are the regular expressions the body must match
+	BodyMatches []*regexp.Regexp
+	// JSONValues are the assertions on the values in a JSON body
+	JSONValues []JSONRule
+}
+
+// HeaderRule asserts that the header is present, and matches the Regexp if it's not nil
+type HeaderRule struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// JSONRule asserts that the value at the Path of a JSON body equals to Value
+// Path is separated by dots, array elements are accessed by index, e.g. data.items.0.id
+// Value is compared with a string as is, otherwise with the JSON encoding of the value, e.g. 3, true, null
+type JSONRule struct {
+	Path  string
+	Value string
+}
+
+// IsEmpty returns whether there is no assertion at all
+func (v *ValidationConfig) IsEmpty() bool {
+	return len(v.StatusCodes) == 0 && len(v.Headers) == 0 && !v.NeedsBody()
+}
+
+// NeedsBody returns whether the body must be captured for the assertions, which is opt-in with
+// LgConfig.CaptureResponse since it's much slower for the clients not reading the body otherwise
+func (v *ValidationConfig) NeedsBody() bool {
+	return len(v.BodyContains) > 0 || len(v.BodyMatches) > 0 || len(v.JSONValues) > 0
+}
+
+// validate returns an error describing the first failed assertion, or nil if the response passes all of them
+func (v *ValidationConfig) validate(response *Response) error {
+	if len(v.StatusCodes) > 0 {
+		expected := false
+		for _, code := range v.StatusCodes {
+			if response.StatusCode == code {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			return fmt.Errorf("unexpected status code %d", response.StatusCode)
+		}
+	}
+	for _, rule := range v.Headers {
+		values := response.Headers.Values(rule.Name)
+		if len(values) == 0 {
+			return fmt.Errorf("header %s is missing", rule.Name)
+		}
+		if rule.Regexp != nil && !matchAny(rule.Regexp, values) {
+			return fmt.Errorf("header %s %q doesn't match %s", rule.Name, values, rule.Regexp)
+		}
+	}
+	for _, substring := range v.BodyContains {
+		if !bytes.Contains(response.Body, []byte(substring)) {
+			return fmt.Errorf("body doesn't contain %q", substring)
+		}
+	}
+	for _, re := range v.BodyMatches {
+		if !re.Match(response.Body) {
+			return fmt.Errorf("body doesn't match %s", re)
+		}
+	}
+	if len(v.JSONValues) > 0 {
+		var document interface{}
+		err := json.Unmarshal(response.Body, &document)
+		if err != nil {
+			return fmt.Errorf("body is not JSON: %v", err)
+		}
+		for _, rule := range v.JSONValues {
+			value, ok := jsonPath(document, rule.Path)
+			if !ok {
+				return fmt.Errorf("JSON path %s is missing", rule.Path)
+			}
+			if value != rule.Value {
+				return fmt.Errorf("JSON path %s is %s, not %s", rule.Path, value, rule.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// matchAny returns whether any of the values matches the regular expression
+func matchAny(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPath returns the value at the dot separated path of the document in string
+func jsonPath(document interface{}, path string) (string, bool) {
+	node := document
+	for _, key := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[key]
+			if !ok {
+				return "", false
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(n) {
+				return "", false
+			}
+			node = n[i]
+		default:
+			return "", false
+		}
+	}
+	if s, ok := node.(string); ok {
+		return s, true
+	}
+	b, err := json.Marshal(node)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
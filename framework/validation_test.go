@@ -0,0 +1,45 @@
+package framework
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJsonPath(t *testing.T) {
+	const document = `{"status":"ok","code":0,"ok":true,"none":null,"ratio":1.5,
+		"data":{"items":[{"id":"a"},{"id":"b","tags":["x","y"]}],"empty":{}}}`
+	var doc interface{}
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		value string
+		found bool
+	}{
+		{path: "status", value: "ok", found: true},
+		{path: "$.status", value: "ok", found: true},
+		{path: "code", value: "0", found: true},
+		{path: "ok", value: "true", found: true},
+		{path: "none", value: "null", found: true},
+		{path: "ratio", value: "1.5", found: true},
+		{path: "data.items.0.id", value: "a", found: true},
+		{path: "$.data.items.1.tags.1", value: "y", found: true},
+		{path: "data.items.1.tags", value: `["x","y"]`, found: true},
+		{path: "data.empty", value: "{}", found: true},
+		{path: "missing"},
+		{path: "data.missing.id"},
+		{path: "data.items.2.id"},
+		{path: "data.items.-1.id"},
+		{path: "data.items.first.id"},
+		{path: "status.length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			value, found := jsonPath(doc, tt.path)
+			if value != tt.value || found != tt.found {
+				t.Errorf("jsonPath(%s) = %q, %v, want %q, %v", tt.path, value, found, tt.value, tt.found)
+			}
+		})
+	}
+}
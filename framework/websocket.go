@@ -0,0 +1,14 @@
+package framework
+
+// WebSocketSeq is the placeholder in the WebSocket templates replaced by the sequence number of the message
+const WebSocketSeq = "{{seq}}"
+
+// WebSocketConfig is the configuration of the WebSocket messages, only for `ws`
+type WebSocketConfig struct {
+	// Message is the template of the text message sent, WebSocketSeq is replaced by the sequence number of the
+	// message on the connection, starting from 1
+	Message string
+	// Match is the template of the substring of the reply to the message, e.g. "id":{{seq}}. The other messages
+	// received are skipped. Any message is the reply if empty
+	Match string
+}
@@ -1,81 +1,337 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/olekukonko/tablewriter"
 	rua "github.com/taoxinyi/rua/framework"
+	"math"
+	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-type Printer struct{}
+const (
+	// spectrumTicksPerHalf is the number of percentiles in the spectrum for each halving of 1-percentile, like wrk2
+	spectrumTicksPerHalf = 5
+	// histogramRows is the number of bins in the terminal latency histogram
+	histogramRows = 20
+	// histogramBarWidth is the width of the longest bar in the terminal latency histogram
+	histogramBarWidth = 50
+)
+
+// spectrumPercentiles are the percentiles in the latency distribution, like wrk2 --latency
+var spectrumPercentiles = []float64{50, 75, 90, 99, 99.9, 99.99, 99.999, 100}
 
-var printer Printer
+type Printer struct {
+	// percentiles are the latency percentiles in the summary table
+	percentiles []float64
+	// spectrum is whether to print the latency distribution as well as the detailed percentile spectrum
+	spectrum bool
+	// histogram is whether to print the latency histogram
+	histogram bool
+}
+
+var printer = Printer{percentiles: defaultPercentiles}
+
+// table is one of the tables in the report
+type table struct {
+	headers []string
+	data    [][]string
+}
 
 func (p *Printer) print(stats *rua.Stats, duration time.Duration) {
+	for _, t := range p.tables(stats, duration) {
+		printTable(t.headers, t.data)
+	}
+	if p.spectrum {
+		p.printSpectrum(stats)
+	}
+	if p.histogram {
+		p.printHistogram(stats)
+	}
+
+	if stats.Events > 0 {
+		fmt.Printf("\n%d responses and %d events received in %s, %s read\n", stats.ResponsesRecv, stats.Events, duration, humanize.IBytes(uint64(stats.BytesRecv+stats.EventBytes)))
+	} else {
+		fmt.Printf("\n%d responses received in %s, %s read\n", stats.ResponsesRecv, duration, humanize.IBytes(uint64(stats.BytesRecv)))
+	}
+
+}
+
+// tables returns the summary tables of the stats
+func (p *Printer) tables(stats *rua.Stats, duration time.Duration) []table {
 	seconds := duration.Seconds()
-	var headers []string
-	var data [][]string
-
-	headers = []string{"", "Connection", "Timeout", "Status"}
-	data = [][]string{{
-		"Errors",
-		fmt.Sprintf("%d", stats.ConnectionErrors),
-		fmt.Sprintf("%d", stats.TimeoutErrors),
-		fmt.Sprintf("%d", stats.StatusErrors),
-	}}
-	printTable(headers, data)
-
-	headers = []string{"", "Avg", "Min", "Max", "Stdev", "+/- Stdev"}
-	data = [][]string{{
-		"Latency",
-		fmt.Sprintf("%.3fms", float64(stats.LatencyMean())/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.MinLatency)/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.MaxLatency)/1000.0),
-		fmt.Sprintf("%.3fms", stats.LatencyStdev()/1000.0),
-		fmt.Sprintf("%.3f%%", stats.LatencyPercentageWithinStdev(1)),
-	}}
-	printTable(headers, data)
-
-	headers = []string{"", "50%", "75%", "90%", "99%", "99.9%"}
-	data = [][]string{{
-		"Latency",
-		fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(50))/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(75))/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(90))/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(99))/1000.0),
-		fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(99.9))/1000.0),
-	}}
-	printTable(headers, data)
-
-	headers = []string{"", "Count", "Count/s", "Size", "Throughput"}
-	data = [][]string{{
-		"Requests",
-		fmt.Sprintf("%d", stats.RequestsSent),
-		fmt.Sprintf("%.2f", float64(stats.RequestsSent)/seconds),
-		fmt.Sprintf("%s", humanize.IBytes(uint64(stats.BytesSent))),
-		fmt.Sprintf("%s/s", humanize.IBytes(uint64(float64(stats.BytesSent)/seconds))),
-	}, {
-		"Responses",
-		fmt.Sprintf("%d", stats.ResponsesRecv),
-		fmt.Sprintf("%.2f", float64(stats.ResponsesRecv)/seconds),
-		fmt.Sprintf("%s", humanize.IBytes(uint64(stats.BytesRecv))),
-		fmt.Sprintf("%s/s", humanize.IBytes(uint64(float64(stats.BytesRecv)/seconds))),
-	},
-	}
-
-	printTable(headers, data)
-
-	fmt.Printf("\n%d responses received in %s, %s read\n", stats.ResponsesRecv, duration, humanize.IBytes(uint64(stats.BytesRecv)))
+	var tables []table
+
+	tables = append(tables, table{
+		headers: []string{"", "Connection", "Timeout", "Status", "Validation"},
+		data: [][]string{{
+			"Errors",
+			fmt.Sprintf("%d", stats.ConnectionErrors),
+			fmt.Sprintf("%d", stats.TimeoutErrors),
+			fmt.Sprintf("%d", stats.StatusErrors),
+			fmt.Sprintf("%d", stats.ValidationErrors),
+		}},
+	})
+
+	if len(stats.ProtocolErrors) > 0 {
+		protocolErrors := table{headers: []string{"Protocol Errors", "Count"}}
+		for kind, count := range stats.ProtocolErrors {
+			protocolErrors.data = append(protocolErrors.data, []string{kind, fmt.Sprintf("%d", count)})
+		}
+		sort.Slice(protocolErrors.data, func(i, j int) bool {
+			return protocolErrors.data[i][0] < protocolErrors.data[j][0]
+		})
+		tables = append(tables, protocolErrors)
+	}
+
+	if len(stats.GRPCStatuses) > 0 {
+		grpcStatuses := table{headers: []string{"gRPC Status", "Count", "Count/s"}}
+		for status, count := range stats.GRPCStatuses {
+			grpcStatuses.data = append(grpcStatuses.data, []string{status, fmt.Sprintf("%d", count), fmt.Sprintf("%.2f", float64(count)/seconds)})
+		}
+		sort.Slice(grpcStatuses.data, func(i, j int) bool {
+			return grpcStatuses.data[i][0] < grpcStatuses.data[j][0]
+		})
+		tables = append(tables, grpcStatuses)
+	}
+
+	if stats.Reconnects > 0 || stats.Recycled > 0 {
+		tables = append(tables, table{
+			headers: []string{"", "Reconnects", "Recycled", "Recycled/s"},
+			data: [][]string{{
+				"Connections",
+				fmt.Sprintf("%d", stats.Reconnects),
+				fmt.Sprintf("%d", stats.Recycled),
+				fmt.Sprintf("%.2f", float64(stats.Recycled)/seconds),
+			}},
+		})
+	}
+
+	if stats.InterimResponses > 0 {
+		tables = append(tables, table{
+			headers: []string{"", "Count", "Count/s"},
+			data: [][]string{{
+				"Interim 1xx",
+				fmt.Sprintf("%d", stats.InterimResponses),
+				fmt.Sprintf("%.2f", float64(stats.InterimResponses)/seconds),
+			}},
+		})
+	}
+
+	if len(stats.RemoteIPConnections) > 0 {
+		tables = append(tables, ipTable("Remote IP", stats.RemoteIPConnections))
+	}
+	if len(stats.LocalIPConnections) > 0 {
+		tables = append(tables, ipTable("Local IP", stats.LocalIPConnections))
+	}
+
+	dials := table{headers: []string{"", "Count", "Count/s", "Avg", "50%", "99%", "Max"}}
+	if stats.Connects.Count > 0 {
+		dials.data = append(dials.data, histogramRow("Connect", &stats.Connects, seconds))
+	}
+	if stats.ProxyConnects.Count > 0 {
+		dials.data = append(dials.data, histogramRow("Proxy", &stats.ProxyConnects, seconds))
+	}
+	if stats.FullHandshakes.Count+stats.ResumedHandshakes.Count+stats.ZeroRTTHandshakes.Count > 0 {
+		dials.data = append(dials.data,
+			histogramRow("Full TLS", &stats.FullHandshakes, seconds),
+			histogramRow("Resumed TLS", &stats.ResumedHandshakes, seconds))
+	}
+	if stats.ZeroRTTHandshakes.Count > 0 {
+		dials.data = append(dials.data, histogramRow("0-RTT TLS", &stats.ZeroRTTHandshakes, seconds))
+	}
+	if stats.Upgrades.Count > 0 {
+		dials.data = append(dials.data, histogramRow("WS Upgrade", &stats.Upgrades, seconds))
+	}
+	if len(dials.data) > 0 {
+		tables = append(tables, dials)
+	}
 
+	if stats.Events > 0 {
+		tables = append(tables, table{
+			headers: []string{"", "Count", "Count/s", "Avg", "50%", "99%", "Max"},
+			data: [][]string{
+				histogramRow("First Event", &stats.FirstEvents, seconds),
+				histogramRow("Event Gap", &stats.EventGaps, seconds),
+			},
+		})
+	}
+
+	// only the events are measured if the responses are all streaming
+	if stats.ResponsesRecv > 0 || stats.Events == 0 {
+		tables = append(tables, table{
+			headers: []string{"", "Avg", "Min", "Max", "Stdev", "+/- Stdev"},
+			data: [][]string{{
+				"Latency",
+				fmt.Sprintf("%.3fms", float64(stats.LatencyMean())/1000.0),
+				fmt.Sprintf("%.3fms", float64(stats.MinLatency)/1000.0),
+				fmt.Sprintf("%.3fms", float64(stats.MaxLatency)/1000.0),
+				fmt.Sprintf("%.3fms", stats.LatencyStdev()/1000.0),
+				fmt.Sprintf("%.3f%%", stats.LatencyPercentageWithinStdev(1)),
+			}},
+		})
+
+		headers := []string{""}
+		row := []string{"Latency"}
+		for _, percentile := range p.percentiles {
+			headers = append(headers, fmt.Sprintf("%s%%", strconv.FormatFloat(percentile, 'f', -1, 64)))
+			row = append(row, fmt.Sprintf("%.3fms", float64(stats.LatencyPercentile(percentile))/1000.0))
+		}
+		tables = append(tables, table{headers: headers, data: [][]string{row}})
+	}
+
+	tables = append(tables, table{
+		headers: []string{"", "Count", "Count/s", "Size", "Throughput"},
+		data: [][]string{{
+			"Requests",
+			fmt.Sprintf("%d", stats.RequestsSent),
+			fmt.Sprintf("%.2f", float64(stats.RequestsSent)/seconds),
+			fmt.Sprintf("%s", humanize.IBytes(uint64(stats.BytesSent))),
+			fmt.Sprintf("%s/s", humanize.IBytes(uint64(float64(stats.BytesSent)/seconds))),
+		}, {
+			"Responses",
+			fmt.Sprintf("%d", stats.ResponsesRecv),
+			fmt.Sprintf("%.2f", float64(stats.ResponsesRecv)/seconds),
+			fmt.Sprintf("%s", humanize.IBytes(uint64(stats.BytesRecv))),
+			fmt.Sprintf("%s/s", humanize.IBytes(uint64(float64(stats.BytesRecv)/seconds))),
+		},
+		},
+	})
+	if stats.Events > 0 {
+		requests := &tables[len(tables)-1]
+		requests.data = append(requests.data, []string{
+			"Events",
+			fmt.Sprintf("%d", stats.Events),
+			fmt.Sprintf("%.2f", float64(stats.Events)/seconds),
+			fmt.Sprintf("%s", humanize.IBytes(uint64(stats.EventBytes))),
+			fmt.Sprintf("%s/s", humanize.IBytes(uint64(float64(stats.EventBytes)/seconds))),
+		})
+	}
+	return tables
+}
+
+// ipTable returns the table of the number of connections of each IP, sorted by the IPs
+func ipTable(name string, connections map[string]int64) table {
+	t := table{headers: []string{name, "Connections"}}
+	for ip, count := range connections {
+		t.data = append(t.data, []string{ip, fmt.Sprintf("%d", count)})
+	}
+	sort.Slice(t.data, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(t.data[i][0]), net.ParseIP(t.data[j][0])) < 0
+	})
+	return t
+}
+
+// histogramRow returns the row of the count, the rate and the latencies of the histogram in microseconds
+func histogramRow(name string, h *rua.Histogram, seconds float64) []string {
+	return []string{
+		name,
+		fmt.Sprintf("%d", h.Count),
+		fmt.Sprintf("%.2f", float64(h.Count)/seconds),
+		fmt.Sprintf("%.3fms", h.Mean()/1000.0),
+		fmt.Sprintf("%.3fms", float64(h.Percentile(50))/1000.0),
+		fmt.Sprintf("%.3fms", float64(h.Percentile(99))/1000.0),
+		fmt.Sprintf("%.3fms", float64(h.Max)/1000.0),
+	}
+}
+
+// printSpectrum prints the latency distribution and the detailed percentile spectrum like wrk2 --latency
+func (p *Printer) printSpectrum(stats *rua.Stats) {
+	fmt.Println(strings.Repeat("-", 72))
+	fmt.Println("Latency Distribution")
+	for _, percentile := range spectrumPercentiles {
+		fmt.Printf("%8.3f%%  %10.3fms\n", percentile, float64(stats.LatencyPercentile(percentile))/1000.0)
+	}
+
+	fmt.Println()
+	fmt.Println("Detailed Percentile Spectrum")
+	fmt.Printf("%12s  %12s  %12s  %16s\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	var totalCount int64 = 0
+	next := stats.MinLatency
+	for i := 0; ; i++ {
+		// percentiles are evenly distributed in log2(1/(1-percentile))
+		inverse := math.Pow(2, float64(i)/spectrumTicksPerHalf)
+		percentile := 100.0 * (1 - 1/inverse)
+		value := stats.LatencyPercentile(percentile)
+		for ; next <= value; next++ {
+			totalCount += stats.Latencies[next]
+		}
+		if totalCount >= stats.ResponsesRecv || inverse > float64(stats.ResponsesRecv) {
+			break
+		}
+		fmt.Printf("%10.3fms  %12.6f  %12d  %16.2f\n", float64(value)/1000.0, percentile/100.0, totalCount, inverse)
+	}
+	fmt.Printf("%10.3fms  %12.6f  %12d  %16s\n", float64(stats.MaxLatency)/1000.0, 1.0, stats.ResponsesRecv, "inf")
+}
+
+// printHistogram prints the latency histogram with bars of '#'
+func (p *Printer) printHistogram(stats *rua.Stats) {
+	fmt.Println(strings.Repeat("-", 72))
+	fmt.Println("Latency Histogram (the last bin includes the latencies above 99.9%)")
+	lowers, width, counts := latencyHistogram(stats, histogramRows)
+	var maxCount int64 = 0
+	for _, count := range counts {
+		maxCount = max(maxCount, count)
+	}
+	for i, count := range counts {
+		bar := 0
+		if count > 0 {
+			// at least one '#' so that small modes are still visible
+			bar = int(math.Max(math.Round(float64(count)/float64(maxCount)*histogramBarWidth), 1))
+		}
+		fmt.Printf("%10.3fms - %10.3fms  %10d  %s\n",
+			float64(lowers[i])/1000.0, float64(lowers[i]+width)/1000.0, count, strings.Repeat("#", bar))
+	}
+}
+
+// latencyHistogram puts Stats.Latencies from the min latency to the 99.9th percentile into the number of bins,
+// the last bin includes the rest. It returns the lower bound of each bin, the bin width and the count of each bin.
+func latencyHistogram(stats *rua.Stats, bins int) (lowers []int64, width int64, counts []int64) {
+	lower := stats.MinLatency
+	upper := stats.LatencyPercentile(99.9)
+	if stats.ResponsesRecv == 0 || upper < lower {
+		return nil, 0, nil
+	}
+	width = (upper-lower)/int64(bins) + 1
+	counts = make([]int64, bins)
+	for i := lower; i <= stats.MaxLatency; i++ {
+		bin := int((i - lower) / width)
+		if bin >= bins {
+			bin = bins - 1
+		}
+		counts[bin] += stats.Latencies[i]
+	}
+	for i := 0; i < bins; i++ {
+		lowers = append(lowers, lower+int64(i)*width)
+	}
+	return lowers, width, counts
 }
+
+func max(a, b int64) int64 {
+	if a < b {
+		return b
+	}
+	return a
+}
+
 func printTable(headers []string, data [][]string) {
 	fmt.Println(strings.Repeat("-", 72))
 	table := tablewriter.NewWriter(os.Stdout)
 	for i := 0; i < len(headers); i++ {
-		table.SetColMinWidth(i, 12)
+		// at least a space after the longest cell, e.g. an IPv6 address
+		width := 12
+		for _, row := range data {
+			if i < len(row) && len(row[i]) >= width {
+				width = len(row[i]) + 1
+			}
+		}
+		table.SetColMinWidth(i, width)
 	}
 	table.SetHeader(headers)
 	table.SetAutoWrapText(false)
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"html/template"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// histogramBins is the number of bins in the latency histogram
+	histogramBins = 50
+	// maxPercentileDecade is the max percentile shown in the distribution, 5 => 99.999%
+	maxPercentileDecade = 5
+	// percentileStepsPerDecade is the number of points between 90% and 99%, 99% and 99.9%, etc.
+	percentileStepsPerDecade = 10
+)
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>rua report - {{.URL}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 860px; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; margin: 1em 0; } th, td { text-align: left; padding: 4px 16px 4px 0; min-width: 90px; }
+th { color: #666; font-weight: normal; } td:first-child { font-weight: bold; }
+.chart { width: 100%; font-size: 11px; } .chart .grid { stroke: #eee; } .chart text { fill: #444; }
+</style>
+</head>
+<body>
+<h1>rua report</h1>
+<p>{{.Duration}} test @ <code>{{.URL}}</code> with {{.Connections}} connections using the {{.Client}} client, generated at {{.Generated}}</p>
+<h2>Summary</h2>
+{{range .Tables}}<table>
+<tr>{{range .Headers}}<th>{{.}}</th>{{end}}</tr>
+{{range .Data}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+<h2>Latency Distribution</h2>
+{{.Percentiles}}
+<h2>Latency Histogram</h2>
+<p>From the min latency to the 99.9th percentile, the last bin includes the rest</p>
+{{.Histogram}}
+<h2>Throughput over Time</h2>
+{{.Throughput}}
+<h2>Latency over Time</h2>
+{{.Latency}}
+</body>
+</html>
+`))
+
+// reportTable is a table in the template
+type reportTable struct {
+	Headers []string
+	Data    [][]string
+}
+
+// report is the data of the template
+type report struct {
+	URL         string
+	Client      string
+	Connections int
+	Duration    time.Duration
+	Generated   string
+	Tables      []reportTable
+	Percentiles template.HTML
+	Histogram   template.HTML
+	Throughput  template.HTML
+	Latency     template.HTML
+}
+
+func formatMs(us float64) string {
+	return fmt.Sprintf("%.2fms", us/1000.0)
+}
+
+func formatSeconds(s float64) string {
+	return fmt.Sprintf("%.1fs", s)
+}
+
+func formatCount(n float64) string {
+	return fmt.Sprintf("%.0f", n)
+}
+
+// percentileChart plots the latency of each percentile, the x axis is 1/(1-percentile) in log scale
+func percentileChart(stats *rua.Stats) *chart {
+	decades := maxPercentileDecade
+	if stats.ResponsesRecv > 0 {
+		// higher percentiles are meaningless without enough responses
+		decades = int(math.Min(float64(decades), math.Ceil(math.Log10(float64(stats.ResponsesRecv)))))
+	}
+	s := series{name: "Latency"}
+	for i := 0; i <= decades*percentileStepsPerDecade; i++ {
+		x := float64(i) / percentileStepsPerDecade
+		percentile := 100.0 * (1 - math.Pow(10, -x))
+		s.x = append(s.x, x)
+		s.y = append(s.y, float64(stats.LatencyPercentile(percentile)))
+	}
+	var ticks []tick
+	for i := 0; i <= decades; i++ {
+		// 0%, 90%, 99%, 99.9%, etc.
+		precision := int(math.Max(float64(i-2), 0))
+		ticks = append(ticks, tick{float64(i), fmt.Sprintf("%.*f%%", precision, 100.0*(1-math.Pow(10, -float64(i))))})
+	}
+	return &chart{xLabel: "Percentile", yLabel: "Latency", series: []series{s}, xTicks: ticks, formatY: formatMs}
+}
+
+// histogramChart plots the latency histogram with histogramBins bins
+func histogramChart(stats *rua.Stats) *chart {
+	s := series{name: "Responses"}
+	lowers, width, counts := latencyHistogram(stats, histogramBins)
+	for i, count := range counts {
+		s.x = append(s.x, float64(lowers[i]+width/2))
+		s.y = append(s.y, float64(count))
+	}
+	return &chart{xLabel: "Latency", yLabel: "Responses", series: []series{s}, bars: true, formatX: formatMs, formatY: formatCount}
+}
+
+// timelineCharts plots the throughput and the latency of each interval until the actual running time
+func timelineCharts(stats *rua.Stats, duration time.Duration) (throughput *chart, latency *chart) {
+	interval := stats.TimelineInterval.Seconds()
+	intervals := int(math.Ceil(duration.Seconds() / interval))
+	if intervals > len(stats.Timeline) {
+		intervals = len(stats.Timeline)
+	}
+	rps := series{name: "Responses/s"}
+	mean := series{name: "Avg"}
+	max := series{name: "Max"}
+	// the events of the streaming responses, the times to the first events or the gaps are their latencies
+	eps := series{name: "Events/s"}
+	eventMean := series{name: "Event Avg"}
+	eventMax := series{name: "Event Max"}
+	for i := 0; i < intervals; i++ {
+		x := float64(i+1) * interval
+		rps.x = append(rps.x, x)
+		rps.y = append(rps.y, float64(stats.Timeline[i].Responses)/interval)
+		mean.x = append(mean.x, x)
+		mean.y = append(mean.y, stats.Timeline[i].LatencyMean())
+		max.x = append(max.x, x)
+		max.y = append(max.y, float64(stats.Timeline[i].MaxLatency))
+		eps.x = append(eps.x, x)
+		eps.y = append(eps.y, float64(stats.Timeline[i].Events)/interval)
+		eventMean.x = append(eventMean.x, x)
+		eventMean.y = append(eventMean.y, stats.Timeline[i].EventLatencyMean())
+		eventMax.x = append(eventMax.x, x)
+		eventMax.y = append(eventMax.y, float64(stats.Timeline[i].MaxEventLatency))
+	}
+	throughputs := []series{rps}
+	latencies := []series{mean, max}
+	perSecond := "Responses/s"
+	if stats.Events > 0 {
+		throughputs = append(throughputs, eps)
+		latencies = append(latencies, eventMean, eventMax)
+		perSecond = "Per Second"
+	}
+	throughput = &chart{xLabel: "Time", yLabel: perSecond, series: throughputs, formatX: formatSeconds, formatY: formatCount}
+	latency = &chart{xLabel: "Time", yLabel: "Latency", series: latencies, formatX: formatSeconds, formatY: formatMs}
+	return throughput, latency
+}
+
+// writeReport writes a self-contained HTML report with the summary tables and the latency charts
+func writeReport(path string, config *rua.LgConfig, clientName string, stats *rua.Stats, duration time.Duration) error {
+	r := &report{
+		URL:         config.RequestConfig.URL,
+		Client:      clientName,
+		Connections: config.Connections,
+		Duration:    config.Duration,
+		Generated:   time.Now().Format(time.RFC1123),
+	}
+	for _, t := range printer.tables(stats, duration) {
+		r.Tables = append(r.Tables, reportTable{Headers: t.headers, Data: t.data})
+	}
+	r.Percentiles = percentileChart(stats).SVG()
+	r.Histogram = histogramChart(stats).SVG()
+	throughput, latency := timelineCharts(stats, duration)
+	r.Throughput = throughput.SVG()
+	r.Latency = latency.SVG()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Resolve overrides the IPs of the host:port, given like curl --resolve example.com:443:127.0.0.1,::1
+// it can be given multiple times for different hosts
+type Resolve map[string][]net.IP
+
+func (r *Resolve) Type() string {
+	return "host:port:addr"
+}
+
+func (r *Resolve) String() string {
+	var values []string
+	for address, ips := range *r {
+		var addrs []string
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+		values = append(values, address+":"+strings.Join(addrs, ","))
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+func (r *Resolve) Set(s string) error {
+	// the host can't have a colon, but the IPv6 addrs can, so the host:port is before the second colon
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("resolve must be like host:port:addr, got %s", s)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(parts[0], "["), "]")
+	var ips []net.IP
+	for _, value := range strings.Split(parts[2], ",") {
+		value = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(value), "["), "]")
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid IP %s", value)
+		}
+		ips = append(ips, ip)
+	}
+	if *r == nil {
+		*r = make(Resolve)
+	}
+	address := net.JoinHostPort(host, parts[1])
+	(*r)[address] = append((*r)[address], ips...)
+	return nil
+}
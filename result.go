@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	rua "github.com/taoxinyi/rua/framework"
+	"io/ioutil"
+	"time"
+)
+
+// defaultPercentiles are the latency percentiles shown in the report and saved in a Result
+var defaultPercentiles = []float64{50, 75, 90, 99, 99.9}
+
+// Result is the summary of a finished test, it can be saved to a JSON file and compared with another one later
+// All latencies are in microseconds (us, 1/1000ms), the same unit as rua.Stats
+type Result struct {
+	URL         string  `json:"url"`
+	Client      string  `json:"client"`
+	Connections int     `json:"connections"`
+	Seconds     float64 `json:"seconds"`
+
+	RequestsSent  int64 `json:"requests_sent"`
+	ResponsesRecv int64 `json:"responses_recv"`
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesRecv     int64 `json:"bytes_recv"`
+
+	ConnectionErrors int64 `json:"connection_errors"`
+	TimeoutErrors    int64 `json:"timeout_errors"`
+	StatusErrors     int64 `json:"status_errors"`
+	ValidationErrors int64 `json:"validation_errors"`
+	// ProtocolErrors is the number of requests failed by each kind of protocol error, e.g. RST_STREAM CANCEL
+	// GRPCStatuses is the number of responses of each gRPC status code, e.g. OK, the others are StatusErrors
+	ProtocolErrors   map[string]int64 `json:"protocol_errors,omitempty"`
+	GRPCStatuses     map[string]int64 `json:"grpc_statuses,omitempty"`
+	Reconnects       int64            `json:"reconnects"`
+	Recycled         int64            `json:"recycled"`
+	InterimResponses int64            `json:"interim_responses"`
+
+	// RemoteIPConnections and LocalIPConnections are the number of connections to each IP of the host and bound to
+	// each local IP
+	RemoteIPConnections map[string]int64 `json:"remote_ip_connections,omitempty"`
+	LocalIPConnections  map[string]int64 `json:"local_ip_connections,omitempty"`
+
+	// Connects is the number of TCP or Unix domain socket connections established, ConnectMean is their mean connect
+	// time
+	Connects    int64   `json:"connects"`
+	ConnectMean float64 `json:"connect_mean_us"`
+
+	// FullHandshakes, ResumedHandshakes and ZeroRTTHandshakes are the number of TLS handshakes, and their mean
+	// durations
+	FullHandshakes       int64   `json:"full_handshakes"`
+	FullHandshakeMean    float64 `json:"full_handshake_mean_us"`
+	ResumedHandshakes    int64   `json:"resumed_handshakes"`
+	ResumedHandshakeMean float64 `json:"resumed_handshake_mean_us"`
+	ZeroRTTHandshakes    int64   `json:"zero_rtt_handshakes"`
+	ZeroRTTHandshakeMean float64 `json:"zero_rtt_handshake_mean_us"`
+
+	// Upgrades is the number of WebSocket upgrade handshakes, UpgradeMean is their mean duration
+	Upgrades    int64   `json:"upgrades,omitempty"`
+	UpgradeMean float64 `json:"upgrade_mean_us,omitempty"`
+
+	// Events is the number of the events of the streaming responses, EventThroughput is the number of them per second
+	// FirstEvent and EventGap are the mean and the 99th percentile of the times to the first events and between the
+	// events
+	Events          int64   `json:"events,omitempty"`
+	EventThroughput float64 `json:"event_throughput,omitempty"`
+	FirstEventMean  float64 `json:"first_event_mean_us,omitempty"`
+	FirstEventP99   int64   `json:"first_event_p99_us,omitempty"`
+	EventGapMean    float64 `json:"event_gap_mean_us,omitempty"`
+	EventGapP99     int64   `json:"event_gap_p99_us,omitempty"`
+
+	// Throughput is the number of responses received per second
+	Throughput   float64             `json:"throughput"`
+	LatencyMean  float64             `json:"latency_mean_us"`
+	LatencyMin   int64               `json:"latency_min_us"`
+	LatencyMax   int64               `json:"latency_max_us"`
+	LatencyStdev float64             `json:"latency_stdev_us"`
+	Percentiles  []PercentileLatency `json:"percentiles"`
+}
+
+// PercentileLatency is the latency for a given percentile
+type PercentileLatency struct {
+	Percentile float64 `json:"percentile"`
+	Latency    int64   `json:"latency_us"`
+}
+
+// newResult summarizes the stats of a test with the latencies of the given percentiles
+func newResult(config *rua.LgConfig, clientName string, stats *rua.Stats, duration time.Duration, percentiles []float64) *Result {
+	seconds := duration.Seconds()
+	result := &Result{
+		URL:              config.RequestConfig.URL,
+		Client:           clientName,
+		Connections:      config.Connections,
+		Seconds:          seconds,
+		RequestsSent:     stats.RequestsSent,
+		ResponsesRecv:    stats.ResponsesRecv,
+		BytesSent:        stats.BytesSent,
+		BytesRecv:        stats.BytesRecv,
+		ConnectionErrors: stats.ConnectionErrors,
+		TimeoutErrors:    stats.TimeoutErrors,
+		StatusErrors:     stats.StatusErrors,
+		ValidationErrors: stats.ValidationErrors,
+		ProtocolErrors:   stats.ProtocolErrors,
+		GRPCStatuses:     stats.GRPCStatuses,
+		Reconnects:       stats.Reconnects,
+		Recycled:         stats.Recycled,
+		InterimResponses: stats.InterimResponses,
+
+		RemoteIPConnections: stats.RemoteIPConnections,
+		LocalIPConnections:  stats.LocalIPConnections,
+
+		Connects:    stats.Connects.Count,
+		ConnectMean: stats.Connects.Mean(),
+
+		FullHandshakes:       stats.FullHandshakes.Count,
+		FullHandshakeMean:    stats.FullHandshakes.Mean(),
+		ResumedHandshakes:    stats.ResumedHandshakes.Count,
+		ResumedHandshakeMean: stats.ResumedHandshakes.Mean(),
+		ZeroRTTHandshakes:    stats.ZeroRTTHandshakes.Count,
+		ZeroRTTHandshakeMean: stats.ZeroRTTHandshakes.Mean(),
+		Upgrades:             stats.Upgrades.Count,
+		UpgradeMean:          stats.Upgrades.Mean(),
+		Events:               stats.Events,
+		EventThroughput:      float64(stats.Events) / seconds,
+		FirstEventMean:       stats.FirstEvents.Mean(),
+		FirstEventP99:        stats.FirstEvents.Percentile(99),
+		EventGapMean:         stats.EventGaps.Mean(),
+		EventGapP99:          stats.EventGaps.Percentile(99),
+		Throughput:           float64(stats.ResponsesRecv) / seconds,
+		LatencyMean:          stats.LatencyMean(),
+		LatencyMin:           stats.MinLatency,
+		LatencyMax:           stats.MaxLatency,
+		LatencyStdev:         stats.LatencyStdev(),
+	}
+	for _, percentile := range percentiles {
+		result.Percentiles = append(result.Percentiles, PercentileLatency{
+			Percentile: percentile,
+			Latency:    stats.LatencyPercentile(percentile),
+		})
+	}
+	return result
+}
+
+// saveResult writes the result to the file in JSON
+func saveResult(result *Result, path string) error {
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// loadResult reads the result from a JSON file written by saveResult
+func loadResult(path string) (*Result, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{}
+	err = json.Unmarshal(b, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
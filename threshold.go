@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	thresholdErrors     = "errors"
+	thresholdRps        = "rps"
+	thresholdMean       = "mean"
+	thresholdMin        = "min"
+	thresholdMax        = "max"
+	thresholdStdev      = "stdev"
+	thresholdPercentile = "p"
+)
+
+// thresholdOperators are ordered so that the 2-byte operators are matched first
+var thresholdOperators = []string{"<=", ">=", "<", ">"}
+
+// threshold is a pass/fail condition for a metric of the final stats, e.g. p99<200ms, errors<1%, rps>5000
+type threshold struct {
+	// expression is the original expression
+	expression string
+	metric     string
+	operator   string
+	// value is in microseconds for latencies, a percentage if percentage is true, otherwise the plain number
+	value      float64
+	percentage bool
+	// percentile is used only if the metric is a percentile
+	percentile float64
+}
+
+// Thresholds is the list of threshold expressions given in the command line
+type Thresholds []threshold
+
+func (t *Thresholds) Type() string {
+	return "string"
+}
+
+func (t *Thresholds) String() string {
+	var expressions []string
+	for _, th := range *t {
+		expressions = append(expressions, th.expression)
+	}
+	return strings.Join(expressions, ",")
+}
+
+func (t *Thresholds) Set(s string) error {
+	th, err := parseThreshold(s)
+	if err != nil {
+		return err
+	}
+	*t = append(*t, *th)
+	return nil
+}
+
+// parseThreshold parses an expression of "metric operator value"
+// metric is one of errors, rps, mean, min, max, stdev or a percentile like p99.9
+// latencies must be a duration like 200ms, errors can be either a count or a percentage like 1%
+func parseThreshold(expression string) (*threshold, error) {
+	s := strings.ReplaceAll(expression, " ", "")
+	th := &threshold{expression: s}
+	i := -1
+	for _, operator := range thresholdOperators {
+		if i = strings.Index(s, operator); i != -1 {
+			th.operator = operator
+			break
+		}
+	}
+	if i <= 0 {
+		return nil, fmt.Errorf("threshold %q must be the format of metric<value, metric>value, etc", expression)
+	}
+	th.metric = strings.ToLower(s[:i])
+	value := s[i+len(th.operator):]
+
+	var err error
+	switch th.metric {
+	case thresholdErrors:
+		th.percentage = strings.HasSuffix(value, "%")
+		th.value, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	case thresholdRps:
+		th.value, err = strconv.ParseFloat(value, 64)
+	case thresholdMean, thresholdMin, thresholdMax, thresholdStdev:
+		th.value, err = parseLatency(value)
+	default:
+		if !strings.HasPrefix(th.metric, thresholdPercentile) {
+			return nil, fmt.Errorf("threshold %q has unknown metric %s", expression, th.metric)
+		}
+		th.percentile, err = strconv.ParseFloat(th.metric[len(thresholdPercentile):], 64)
+		if err != nil || th.percentile < 0 || th.percentile > 100 {
+			return nil, fmt.Errorf("threshold %q has invalid percentile %s", expression, th.metric)
+		}
+		th.value, err = parseLatency(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("threshold %q has invalid value %s", expression, value)
+	}
+	return th, nil
+}
+
+// parseLatency parses a duration like 200ms to microseconds
+func parseLatency(s string) (float64, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("latency must not be negative")
+	}
+	return float64(d.Microseconds()), nil
+}
+
+// actual returns the actual value of the metric in the same unit as value, as well as a readable form of it
+// it's NaN for a latency if no response is received, which fails the threshold
+func (t *threshold) actual(stats *rua.Stats, duration time.Duration) (float64, string) {
+	var us float64
+	switch t.metric {
+	case thresholdErrors:
+		errs := float64(stats.Errors())
+		if !t.percentage {
+			return errs, fmt.Sprintf("%.0f", errs)
+		}
+		percentage := 0.0
+		if stats.RequestsSent > 0 {
+			percentage = 100.0 * errs / float64(stats.RequestsSent)
+		}
+		return percentage, fmt.Sprintf("%.3f%%", percentage)
+	case thresholdRps:
+		rps := float64(stats.ResponsesRecv) / duration.Seconds()
+		return rps, fmt.Sprintf("%.2f", rps)
+	}
+	if stats.ResponsesRecv == 0 {
+		return math.NaN(), "no responses"
+	}
+	switch t.metric {
+	case thresholdMean:
+		us = stats.LatencyMean()
+	case thresholdMin:
+		us = float64(stats.MinLatency)
+	case thresholdMax:
+		us = float64(stats.MaxLatency)
+	case thresholdStdev:
+		us = stats.LatencyStdev()
+	default:
+		us = float64(stats.LatencyPercentile(t.percentile))
+	}
+	return us, fmt.Sprintf("%.3fms", us/1000.0)
+}
+
+// passed returns whether the actual value satisfies the threshold
+func (t *threshold) passed(actual float64) bool {
+	if math.IsNaN(actual) {
+		return false
+	}
+	switch t.operator {
+	case "<":
+		return actual < t.value
+	case "<=":
+		return actual <= t.value
+	case ">":
+		return actual > t.value
+	default:
+		return actual >= t.value
+	}
+}
+
+// evaluateThresholds prints a pass/fail summary of all thresholds and returns whether all of them passed
+func evaluateThresholds(thresholds Thresholds, stats *rua.Stats, duration time.Duration) bool {
+	allPassed := true
+	headers := []string{"Threshold", "Actual", "Result"}
+	var data [][]string
+	for i := range thresholds {
+		th := &thresholds[i]
+		actual, readable := th.actual(stats, duration)
+		verdict := "pass"
+		if !th.passed(actual) {
+			verdict = "FAIL"
+			allPassed = false
+		}
+		data = append(data, []string{th.expression, readable, verdict})
+	}
+	printTable(headers, data)
+	if allPassed {
+		fmt.Printf("\nAll %d thresholds passed\n", len(thresholds))
+	} else {
+		fmt.Printf("\nThresholds failed\n")
+	}
+	return allPassed
+}
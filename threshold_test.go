@@ -0,0 +1,76 @@
+package main
+
+import (
+	rua "github.com/taoxinyi/rua/framework"
+	"testing"
+	"time"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       threshold
+		wantErr    bool
+	}{
+		{expression: "p99<200ms", want: threshold{metric: "p99", operator: "<", value: 200000, percentile: 99}},
+		{expression: "P99.9 <= 1.5s", want: threshold{metric: "p99.9", operator: "<=", value: 1500000, percentile: 99.9}},
+		{expression: "p100<1s", want: threshold{metric: "p100", operator: "<", value: 1000000, percentile: 100}},
+		{expression: "mean<10ms", want: threshold{metric: "mean", operator: "<", value: 10000}},
+		{expression: "min>=100us", want: threshold{metric: "min", operator: ">=", value: 100}},
+		{expression: "max<1m", want: threshold{metric: "max", operator: "<", value: 60000000}},
+		{expression: "stdev<5ms", want: threshold{metric: "stdev", operator: "<", value: 5000}},
+		{expression: "errors<1%", want: threshold{metric: "errors", operator: "<", value: 1, percentage: true}},
+		{expression: "errors<=10", want: threshold{metric: "errors", operator: "<=", value: 10}},
+		{expression: "rps>5000", want: threshold{metric: "rps", operator: ">", value: 5000}},
+		{expression: "RPS >= 1.5", want: threshold{metric: "rps", operator: ">=", value: 1.5}},
+		{expression: "p99", wantErr: true},
+		{expression: "<200ms", wantErr: true},
+		{expression: "p99=200ms", wantErr: true},
+		{expression: "p99<200", wantErr: true},
+		{expression: "p99<-1ms", wantErr: true},
+		{expression: "p101<1s", wantErr: true},
+		{expression: "px<1s", wantErr: true},
+		{expression: "latency<1s", wantErr: true},
+		{expression: "rps>fast", wantErr: true},
+		{expression: "errors<1%%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			th, err := parseThreshold(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseThreshold(%q) = %+v, want an error", tt.expression, *th)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseThreshold(%q) = %v", tt.expression, err)
+			}
+			tt.want.expression = th.expression
+			if *th != tt.want {
+				t.Errorf("parseThreshold(%q) = %+v, want %+v", tt.expression, *th, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholdNoResponses(t *testing.T) {
+	stats := &rua.Stats{RequestsSent: 10}
+	for _, expression := range []string{"mean<1s", "min<1s", "max<1s", "stdev<1s", "p99<1s", "p50>=0ms"} {
+		th, err := parseThreshold(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, readable := th.actual(stats, time.Second)
+		if th.passed(value) || readable != "no responses" {
+			t.Errorf("%s passed %v with %q, want failed with no responses", expression, th.passed(value), readable)
+		}
+	}
+	th, err := parseThreshold("rps<=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := th.actual(stats, time.Second); !th.passed(value) {
+		t.Errorf("rps<=0 failed with %v responses/s, want passed", value)
+	}
+}
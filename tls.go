@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tlsVersions are the TLS versions by their names in the flags
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSVersion is a TLS version like 1.2, the default of crypto/tls if it's 0
+type TLSVersion uint16
+
+func (v *TLSVersion) Type() string {
+	return "version"
+}
+
+func (v *TLSVersion) String() string {
+	for name, version := range tlsVersions {
+		if uint16(*v) == version {
+			return name
+		}
+	}
+	return ""
+}
+
+func (v *TLSVersion) Set(s string) error {
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(s), "tls")]
+	if !ok {
+		return fmt.Errorf("TLS version must be one of 1.0, 1.1, 1.2, 1.3, got %s", s)
+	}
+	*v = TLSVersion(version)
+	return nil
+}
+
+// CipherSuites are the cipher suites by their names like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or IDs like 0xc02f
+type CipherSuites []uint16
+
+func (c *CipherSuites) Type() string {
+	return "strings"
+}
+
+func (c *CipherSuites) String() string {
+	var names []string
+	for _, id := range *c {
+		names = append(names, tls.CipherSuiteName(id))
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *CipherSuites) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		id, err := cipherSuiteID(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		*c = append(*c, id)
+	}
+	return nil
+}
+
+// cipherSuiteID returns the ID of a cipher suite given its name or ID
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if strings.EqualFold(suite.Name, name) {
+			return suite.ID, nil
+		}
+	}
+	id, err := strconv.ParseUint(name, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown cipher suite %s", name)
+	}
+	return uint16(id), nil
+}
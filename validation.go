@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	rua "github.com/taoxinyi/rua/framework"
+	"regexp"
+	"strings"
+)
+
+// HeaderRules are the assertions on the response headers, each one is either "Name" or "Name: regexp"
+type HeaderRules []rua.HeaderRule
+
+func (h *HeaderRules) Type() string {
+	return "string"
+}
+
+func (h *HeaderRules) String() string {
+	var rules []string
+	for _, rule := range *h {
+		if rule.Regexp == nil {
+			rules = append(rules, rule.Name)
+		} else {
+			rules = append(rules, fmt.Sprintf("%s: %s", rule.Name, rule.Regexp))
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+func (h *HeaderRules) Set(s string) error {
+	rule := rua.HeaderRule{Name: strings.TrimSpace(s)}
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		rule.Name = strings.TrimSpace(s[:i])
+		re, err := regexp.Compile(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return err
+		}
+		rule.Regexp = re
+	}
+	if rule.Name == "" {
+		return errors.New("header must be the format of name or name: regexp")
+	}
+	*h = append(*h, rule)
+	return nil
+}
+
+// Regexps are the regular expressions the body must match
+type Regexps []*regexp.Regexp
+
+func (r *Regexps) Type() string {
+	return "string"
+}
+
+func (r *Regexps) String() string {
+	var res []string
+	for _, re := range *r {
+		res = append(res, re.String())
+	}
+	return strings.Join(res, ",")
+}
+
+func (r *Regexps) Set(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, re)
+	return nil
+}
+
+// JSONRules are the assertions on a JSON body, each one is the format of "path=value"
+type JSONRules []rua.JSONRule
+
+func (j *JSONRules) Type() string {
+	return "string"
+}
+
+func (j *JSONRules) String() string {
+	var rules []string
+	for _, rule := range *j {
+		rules = append(rules, fmt.Sprintf("%s=%s", rule.Path, rule.Value))
+	}
+	return strings.Join(rules, ",")
+}
+
+func (j *JSONRules) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return errors.New("JSON assertion must be the format of path=value")
+	}
+	*j = append(*j, rua.JSONRule{Path: strings.TrimSpace(s[:i]), Value: strings.TrimSpace(s[i+1:])})
+	return nil
+}